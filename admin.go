@@ -0,0 +1,304 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// adminToken is the bearer token the admin API requires. The admin API is
+// disabled when it's empty.
+var adminToken string
+
+// Admin wraps an admin API handler with the bearer token check.
+func Admin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		if adminToken == "" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("403: \"admin API is disabled, use the '-admin-token' flag to enable it\""))
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="airport"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("401: \"unauthorized\""))
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500: \"" + err.Error() + "\""))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bytes)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.WriteHeader(status)
+	w.Write([]byte(strconv.Itoa(status) + ": \"" + msg + "\""))
+}
+
+// readJSON decodes the request body into v, answering 400 if it can't.
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	return true
+}
+
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, r.Method+" not allowed")
+	return false
+}
+
+// POST /admin/enable opens the airport to passengers.
+func (airport *Airport) HandleAdminEnable(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	airport.Mutex.Lock()
+	airport.SetDisabled(false)
+	airport.Mutex.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// POST /admin/disable closes the airport and sends the passengers away.
+func (airport *Airport) HandleAdminDisable(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	airport.Mutex.Lock()
+	airport.SetDisabled(true)
+	airport.Mutex.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// POST /admin/reset drops every participant and publishes Reset.
+func (airport *Airport) HandleAdminReset(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	airport.Mutex.Lock()
+	airport.Reset()
+	airport.Mutex.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// POST /admin/disconnect {"name": "Supplier.X"} forcibly disconnects a
+// participant.
+func (airport *Airport) HandleAdminDisconnect(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodPost) {
+		return
+	}
+
+	var body struct {
+		Name string `json:"name"`
+	}
+	if !readJSON(w, r, &body) {
+		return
+	}
+
+	airport.Mutex.Lock()
+	found := airport.DisconnectParticipant(body.Name, "")
+	airport.Mutex.Unlock()
+
+	if !found {
+		writeError(w, http.StatusNotFound, "no participant named "+body.Name)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /admin/bans lists the ban rules. POST {"rule": "Carrier.IBM*",
+// "expires": "30m"} adds one, expires being optional and either an RFC 3339
+// time or a duration from now. DELETE {"rule": "Carrier.IBM*"} removes a rule
+// added through the API; rules from the ban file have to be removed there.
+func (airport *Airport) HandleAdminBans(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPost, http.MethodDelete) {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		airport.rules_mu.RLock()
+		list := append([]BanRule{}, airport.bans...)
+		airport.rules_mu.RUnlock()
+		writeJSON(w, list)
+		return
+	}
+
+	var body struct {
+		Rule    string `json:"rule"`
+		Expires string `json:"expires"`
+	}
+	if !readJSON(w, r, &body) {
+		return
+	}
+
+	rule, err := ParseBanRule(body.Rule)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		if body.Expires != "" {
+			t, err := time.Parse(time.RFC3339, body.Expires)
+			if d, derr := time.ParseDuration(body.Expires); derr == nil {
+				t, err = time.Now().Add(d), nil
+			}
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "expires must be an RFC 3339 time or a duration")
+				return
+			}
+			rule.Expires = &t
+		}
+
+		airport.rules_mu.Lock()
+		airport.bans = append(append([]BanRule{}, airport.bans...), rule)
+		airport.rules_mu.Unlock()
+		airport.DisconnectBanned()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	airport.rules_mu.Lock()
+	defer airport.rules_mu.Unlock()
+	rules := []BanRule{}
+	for _, b := range airport.bans {
+		if b.Role == rule.Role && b.Pattern == rule.Pattern {
+			if b.File {
+				writeError(w, http.StatusConflict, "rule comes from the ban file, remove it there")
+				return
+			}
+			continue
+		}
+		rules = append(rules, b)
+	}
+	if len(rules) == len(airport.bans) {
+		writeError(w, http.StatusNotFound, "no such rule")
+		return
+	}
+	airport.bans = rules
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type adminTimeout struct {
+	Index int          `json:"index"`
+	Rule  TimeoutEvent `json:"rule"`
+}
+
+// GET /admin/timeouts lists the watchdog rules. PUT {"name": "retailer-order",
+// "timeout": "30s"} changes the timeout of one rule, picked by name or by
+// "index", or of all of them when neither is given. Changes last until the
+// timeouts file is next reloaded.
+func (airport *Airport) HandleAdminTimeouts(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPut) {
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		var body struct {
+			Index   *int   `json:"index"`
+			Name    string `json:"name"`
+			Timeout string `json:"timeout"`
+		}
+		if !readJSON(w, r, &body) {
+			return
+		}
+
+		d, err := time.ParseDuration(body.Timeout)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "timeout must be a positive duration, e.g. 25s")
+			return
+		}
+
+		// Copied rather than changed in place, see Timeouts
+		airport.rules_mu.Lock()
+		found := false
+		rules := append([]TimeoutEvent{}, airport.timeoutEvents...)
+		for i := range rules {
+			if (body.Index == nil || *body.Index == i) && (body.Name == "" || body.Name == rules[i].Name) {
+				rules[i].Timeout = d
+				found = true
+			}
+		}
+		airport.timeoutEvents = rules
+		airport.rules_mu.Unlock()
+
+		if !found {
+			writeError(w, http.StatusNotFound, "no such timeout rule")
+			return
+		}
+	}
+
+	list := []adminTimeout{}
+	for i, t := range airport.Timeouts() {
+		list = append(list, adminTimeout{i, t})
+	}
+	writeJSON(w, list)
+}
+
+// GET /admin/strategy shows the job assignment strategy, PUT {"strategy":
+// "sticky"} changes it and reassigns the jobs.
+func (airport *Airport) HandleAdminStrategy(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPut) {
+		return
+	}
+
+	var body struct {
+		Strategy  string   `json:"strategy"`
+		Available []string `json:"available"`
+	}
+
+	if r.Method == http.MethodPut {
+		if !readJSON(w, r, &body) {
+			return
+		}
+
+		strategy, ok := Strategies[body.Strategy]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "unknown strategy, use one of: "+strings.Join(StrategyNames(), ", "))
+			return
+		}
+
+		airport.Mutex.Lock()
+		airport.Strategy = strategy
+		airport.UpdateJobs()
+		airport.Mutex.Unlock()
+	}
+
+	airport.Mutex.RLock()
+	for name, s := range Strategies {
+		if s == airport.Strategy {
+			body.Strategy = name
+		}
+	}
+	airport.Mutex.RUnlock()
+	body.Available = StrategyNames()
+	writeJSON(w, body)
+}
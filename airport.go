@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Airport is one airport the controller runs: its participants and
+// customers, the views watching it and the transport its events travel on.
+// Airports share nothing but the catalog, so the parallel tracks of a
+// hackathon can each have one in a single controller, with rules of their
+// own. Events published by any airport but the default one carry its name in
+// the "airport" extension.
+type Airport struct {
+	Name      string       `json:"-"` // "" for the default airport, served at /
+	Disabled  bool         `json:"disabled"`
+	Suppliers []*Supplier  `json:"suppliers"`
+	Retailers []*Retailer  `json:"retailers"`
+	Carriers  []*Carrier   `json:"carriers"`
+	Catalog   Catalog      `json:"catalog"` // set up with the airport and never changed
+	Mutex     sync.RWMutex `json:"-"`
+	Transport Transport    `json:"-"`
+	Strategy  Strategy     `json:"-"`
+	StatePath string       `json:"-"` // file to snapshot the airport to, if any
+
+	clients    []chan string // the views, protected by clients_mu
+	clients_mu sync.Mutex
+
+	sinks []*HTTPSink // set up before the airport starts and never changed
+
+	// The ban, timeout, restock and event token rules, protected by
+	// rules_mu. The airport lock is taken before rules_mu, never after.
+	rules_mu      sync.RWMutex
+	bans          []BanRule // the ban file's rules, then the admin API's
+	timeoutEvents []TimeoutEvent
+	restockRules  []RestockRule
+	eventTokens   map[string]string
+
+	// specVersions remembers the spec version each participant last sent so
+	// events addressed to it can be sent in a version it understands.
+	specVersions    map[string]string
+	specVersions_mu sync.Mutex
+
+	// The rest is protected by the lock
+	ates      map[string]*ActiveTimeoutEvent // keyed by the ID of the event watched
+	orders    []*Order                       // in progress, oldest first
+	finished  []*Order                       // done with, oldest first
+	cooldowns map[string]*Cooldown           // keyed by participant name
+	logEvent  *CloudEvent                    // the event being handled, see Log
+
+	// stats is keyed by participant name and kept across disconnects, so
+	// teams keep their place when they reconnect.
+	stats map[string]*ParticipantStats
+
+	// restoredTimeouts are rearmed once the transport is connected, so that
+	// a timeout that expired while the controller was down can still
+	// publish its disconnect.
+	restoredTimeouts []TimeoutSnapshot
+	restored         bool
+
+	// stopping keeps the timers that fired during shutdown from acting.
+	stopping bool
+
+	routes    *http.ServeMux
+	listening chan struct{} // closed once the listener has returned
+}
+
+// airports are keyed by name, the default airport under "". They're all set
+// up before the controller starts serving and never change after.
+var airports = map[string]*Airport{}
+
+var airportName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func NewAirport(name string, transport Transport, strategy Strategy) *Airport {
+	airport := &Airport{
+		Name:      name,
+		Transport: transport,
+		Strategy:  strategy,
+		ates:      map[string]*ActiveTimeoutEvent{},
+		stats:     map[string]*ParticipantStats{},
+		cooldowns: map[string]*Cooldown{},
+		listening: make(chan struct{}),
+
+		timeoutEvents: append([]TimeoutEvent{}, DefaultTimeoutEvents...),
+
+		specVersions: map[string]string{},
+	}
+	airport.routes = airport.Routes()
+	airports[name] = airport
+	return airport
+}
+
+// AirportNames returns the names of the airports, the default one first.
+func AirportNames() []string {
+	var names []string
+	for name := range airports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AirportList collects the repeatable '-airport' flag: NAME, or
+// NAME=ADDRESS to use another AMQP address than /exchange/NAME.
+type AirportList map[string]string
+
+func (l AirportList) String() string {
+	var list []string
+	for name, address := range l {
+		list = append(list, name+"="+address)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
+
+func (l AirportList) Set(value string) error {
+	name, address, _ := strings.Cut(value, "=")
+	if !airportName.MatchString(name) {
+		return errors.New("airport names are letters, digits, '-' and '_'")
+	}
+	if _, ok := l[name]; ok {
+		return errors.New("airport " + name + " is given twice")
+	}
+	if address == "" {
+		address = "/exchange/" + name
+	}
+	l[name] = address
+	return nil
+}
+
+// AirportPath returns the named airport's own version of a file, such as its
+// snapshot or its ban file: path itself for the default airport, with the
+// name before the extension for the others.
+func AirportPath(path string, name string) string {
+	if path == "" || name == "" {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + name + ext
+}
+
+// Routes returns the airport's HTTP handlers: the pages, its data and
+// metrics, the websockets, the events endpoint and the admin API. The ban and
+// timeout rules are the same whichever airport's admin API they're changed
+// through, as every airport shares them.
+func (airport *Airport) Routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", HandleFileRequest)
+	mux.HandleFunc("/data", airport.HandleDataRequest)
+	mux.HandleFunc("/events", airport.HandleEvents)
+	mux.HandleFunc("/orders", airport.HandleOrders)
+	mux.HandleFunc("/orders/", airport.HandleOrders)
+	mux.HandleFunc("/stats", airport.HandleStats)
+	mux.Handle("/metrics", airport.MetricsHandler())
+	mux.HandleFunc("/admin/enable", Admin(airport.HandleAdminEnable))
+	mux.HandleFunc("/admin/disable", Admin(airport.HandleAdminDisable))
+	mux.HandleFunc("/admin/reset", Admin(airport.HandleAdminReset))
+	mux.HandleFunc("/admin/disconnect", Admin(airport.HandleAdminDisconnect))
+	mux.HandleFunc("/admin/strategy", Admin(airport.HandleAdminStrategy))
+	mux.HandleFunc("/admin/bans", Admin(airport.HandleAdminBans))
+	mux.HandleFunc("/admin/timeouts", Admin(airport.HandleAdminTimeouts))
+	mux.HandleFunc("/ws_view", airport.HandleView)
+	mux.HandleFunc("/ws_customer", airport.HandleCustomer)
+	return mux
+}
+
+// HandleAirport serves /a/{airport}/... from the named airport's routes.
+func HandleAirport(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/a/")
+	name, _, more := strings.Cut(rest, "/")
+	airport, ok := airports[name]
+	if !ok || name == "" {
+		writeError(w, http.StatusNotFound, "no airport "+name)
+		return
+	}
+
+	// The pages use relative URLs
+	if !more {
+		http.Redirect(w, r, "/a/"+name+"/", http.StatusMovedPermanently)
+		return
+	}
+	http.StripPrefix("/a/"+name, airport.routes).ServeHTTP(w, r)
+}
+
+// Start restores the airport from its state file, if any, and listens on
+// its transport until ctx is done, snapshotting it every snapshotInterval
+// and sweeping its orders every orderSweep.
+func (airport *Airport) Start(ctx context.Context, snapshotInterval time.Duration) {
+	if airport.StatePath != "" {
+		if err := airport.LoadSnapshot(airport.StatePath); err != nil {
+			slog.Error("Error restoring snapshot", "airport", airport.Name, "path", airport.StatePath, "err", err)
+		}
+		go airport.SaveSnapshots(ctx, airport.StatePath, snapshotInterval)
+	}
+	go airport.SweepOrders(ctx)
+
+	go func() {
+		airport.Transport.Listen(ctx, airport.OnConnect, func(event *CloudEvent) {
+			journal.Record(airport.Name, JOURNAL_IN, event)
+			airport.ProcessEvent(*event)
+		})
+		close(airport.listening)
+	}()
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"sort"
+)
+
+// Worker is a participant that work can be assigned to.
+type Worker struct {
+	Name     string
+	Capacity int // relative share of the work, at least 1
+	Load     int // requests currently waiting on the participant
+}
+
+// A Strategy decides which participant handles each piece of work UpdateJobs
+// deals out: a retailer's offer for suppliers, a retailer and supplier pair
+// for carriers.
+type Strategy interface {
+	// Assign returns, for each item, the index into workers of the
+	// participant that should handle it. previous maps items to the name of
+	// the participant that handled them before, if any.
+	Assign(items []string, workers []Worker, previous map[string]string) []int
+}
+
+var Strategies = map[string]Strategy{
+	"roundrobin":  RoundRobin{},
+	"leastloaded": LeastLoaded{},
+	"sticky":      Sticky{},
+	"weighted":    Weighted{},
+}
+
+// StrategyNames lists the available strategies, sorted.
+func StrategyNames() []string {
+	var names []string
+	for name := range Strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RoundRobin deals the items out in order. Every join or leave shifts nearly
+// every item to another participant.
+type RoundRobin struct{}
+
+func (RoundRobin) Assign(items []string, workers []Worker, previous map[string]string) []int {
+	assigned := make([]int, len(items))
+	for i := range items {
+		assigned[i] = i % len(workers)
+	}
+	return assigned
+}
+
+// LeastLoaded gives each item to the participant with the least to do,
+// counting both the items it has been given and the requests it hasn't
+// answered yet.
+type LeastLoaded struct{}
+
+func (LeastLoaded) Assign(items []string, workers []Worker, previous map[string]string) []int {
+	load := make([]int, len(workers))
+	for i, w := range workers {
+		load[i] = w.Load
+	}
+
+	assigned := make([]int, len(items))
+	for i := range items {
+		best := 0
+		for w := range workers {
+			if load[w] < load[best] {
+				best = w
+			}
+		}
+		assigned[i] = best
+		load[best]++
+	}
+	return assigned
+}
+
+// Sticky keeps items with the participant that had them as long as that
+// doesn't leave it with more than its fair share, so a join or leave only
+// moves the items it has to.
+type Sticky struct{}
+
+func (Sticky) Assign(items []string, workers []Worker, previous map[string]string) []int {
+	index := map[string]int{}
+	for i, w := range workers {
+		index[w.Name] = i
+	}
+
+	limit := (len(items) + len(workers) - 1) / len(workers)
+	count := make([]int, len(workers))
+	assigned := make([]int, len(items))
+	for i, item := range items {
+		assigned[i] = -1
+		if w, ok := index[previous[item]]; ok && count[w] < limit {
+			assigned[i] = w
+			count[w]++
+		}
+	}
+
+	for i := range items {
+		if assigned[i] != -1 {
+			continue
+		}
+		best := 0
+		for w := range workers {
+			if count[w] < count[best] {
+				best = w
+			}
+		}
+		assigned[i] = best
+		count[best]++
+	}
+	return assigned
+}
+
+// Weighted shares the items out in proportion to each participant's
+// advertised capacity.
+type Weighted struct{}
+
+func (Weighted) Assign(items []string, workers []Worker, previous map[string]string) []int {
+	count := make([]int, len(workers))
+	assigned := make([]int, len(items))
+	for i := range items {
+		best := 0
+		for w := range workers {
+			// Compare (count+1)/capacity without dividing
+			if (count[w]+1)*capacity(workers[best]) < (count[best]+1)*capacity(workers[w]) {
+				best = w
+			}
+		}
+		assigned[i] = best
+		count[best]++
+	}
+	return assigned
+}
+
+func capacity(w Worker) int {
+	if w.Capacity < 1 {
+		return 1
+	}
+	return w.Capacity
+}
+
+// Load counts the pending timeouts waiting on the named participant.
+func (airport *Airport) Load(name string) int {
+	n := 0
+	for _, ate := range airport.ates {
+		if ate.Target == name {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Roles a ban rule can be scoped to. Passengers are the controller's own,
+// their events' source being just "Passenger", so they can't be banned.
+var Roles = []string{"Retailer", "Supplier", "Carrier"}
+
+// BanRule bans the participants whose name, the part of the source after the
+// role, matches Pattern. Pattern is a glob. An empty Role bans the name in
+// every role. A nil Expires never expires.
+type BanRule struct {
+	Role    string     `json:"role,omitempty"`
+	Pattern string     `json:"pattern"`
+	Expires *time.Time `json:"expires,omitempty"`
+	File    bool       `json:"file"`
+}
+
+// ParseBanRule reads a rule written as "[Role.]Pattern [expiry]", e.g.
+// "Carrier.IBMR 2026-01-02T15:04:05Z". The expiry is RFC 3339.
+func ParseBanRule(line string) (BanRule, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || len(fields) > 2 {
+		return BanRule{}, errors.New("expected \"[Role.]Pattern [expiry]\"")
+	}
+
+	rule := BanRule{Pattern: fields[0]}
+	if parts := strings.SplitN(rule.Pattern, ".", 2); len(parts) == 2 {
+		for _, role := range Roles {
+			if parts[0] == role {
+				rule.Role, rule.Pattern = role, parts[1]
+				break
+			}
+		}
+	}
+
+	if _, err := path.Match(rule.Pattern, ""); err != nil || rule.Pattern == "" {
+		return BanRule{}, errors.New("bad pattern " + fields[0])
+	}
+
+	if len(fields) == 2 {
+		t, err := time.Parse(time.RFC3339, fields[1])
+		if err != nil {
+			return BanRule{}, errors.New("bad expiry " + fields[1] + ", use RFC 3339")
+		}
+		rule.Expires = &t
+	}
+
+	return rule, nil
+}
+
+func (rule BanRule) String() string {
+	s := rule.Pattern
+	if rule.Role != "" {
+		s = rule.Role + "." + s
+	}
+	if rule.Expires != nil {
+		s += " " + rule.Expires.Format(time.RFC3339)
+	}
+	return s
+}
+
+// Matches reports whether the rule bans the participant with the given
+// source at time now.
+func (rule BanRule) Matches(source string, now time.Time) bool {
+	if rule.Expires != nil && !now.Before(*rule.Expires) {
+		return false
+	}
+
+	parts := strings.SplitN(source, ".", 2)
+	if len(parts) < 2 {
+		return false
+	}
+	if rule.Role != "" && rule.Role != parts[0] {
+		return false
+	}
+
+	ok, _ := path.Match(rule.Pattern, parts[1])
+	return ok
+}
+
+// IsBanned reports whether any of the airport's rules bans the participant
+// with the given source.
+func (airport *Airport) IsBanned(source string) bool {
+	airport.rules_mu.RLock()
+	defer airport.rules_mu.RUnlock()
+
+	now := time.Now()
+	for _, rule := range airport.bans {
+		if rule.Matches(source, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// DisconnectBanned disconnects the airport's banned participants. It must be
+// called without holding the airport lock or rules_mu.
+func (airport *Airport) DisconnectBanned() {
+	airport.Mutex.Lock()
+	defer airport.Mutex.Unlock()
+	airport.disconnectBanned()
+}
+
+// disconnectBanned disconnects the airport's banned participants. The caller
+// must hold the airport lock.
+func (airport *Airport) disconnectBanned() {
+	var names []string
+	for _, r := range airport.Retailers {
+		names = append(names, r.Name)
+	}
+	for _, s := range airport.Suppliers {
+		names = append(names, s.Name)
+	}
+	for _, c := range airport.Carriers {
+		names = append(names, c.Name)
+	}
+
+	for _, name := range names {
+		if airport.IsBanned(name) {
+			airport.Log().Info("Disconnecting banned participant", "participant", name)
+			airport.DisconnectParticipant(name, "")
+		}
+	}
+}
+
+// ReadBanFile reads one rule per line; blank lines and lines starting with
+// '#' are ignored.
+func ReadBanFile(file string) ([]BanRule, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []BanRule
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		rule, err := ParseBanRule(line)
+		if err != nil {
+			slog.Warn("Ignoring bad ban rule", "file", file, "line", n, "err", err)
+			continue
+		}
+		rule.File = true
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// LoadBanFile replaces the airport's rules that came from the ban file with
+// its current contents, keeping those added through the admin API.
+func (airport *Airport) LoadBanFile(file string) {
+	rules, err := ReadBanFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		slog.Error("Error reading ban file", "airport", airport.Name, "file", file, "err", err)
+		return
+	}
+
+	for _, rule := range rules {
+		slog.Info("Banning", "airport", airport.Name, "rule", rule.String())
+	}
+
+	airport.rules_mu.Lock()
+	for _, rule := range airport.bans {
+		if !rule.File {
+			rules = append(rules, rule)
+		}
+	}
+	airport.bans = rules
+	airport.rules_mu.Unlock()
+	airport.DisconnectBanned()
+}
+
+// WatchFile calls load with the file whenever it changes, is created or is
+// removed, checking every interval. The first check always loads it.
+func WatchFile(file string, interval time.Duration, load func(string)) {
+	var modified time.Time
+	var size int64 = -1
+	first := true
+	for {
+		info, err := os.Stat(file)
+		switch {
+		case err == nil && (!info.ModTime().Equal(modified) || info.Size() != size):
+			modified, size = info.ModTime(), info.Size()
+			load(file)
+		case os.IsNotExist(err) && (size != -1 || first):
+			modified, size = time.Time{}, -1
+			load(file)
+		}
+		first = false
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestRestoredBannedParticipantDisconnected(t *testing.T) {
+	airport := NewAirport("banstest", NewChannelTransport(), RoundRobin{})
+	defer delete(airports, airport.Name)
+
+	airport.Retailers = []*Retailer{
+		{Name: "Retailer.r1", Offers: map[string]int{}, airport: airport},
+		{Name: "Retailer.r2", Offers: map[string]int{}, airport: airport},
+	}
+	airport.bans = []BanRule{{Role: "Retailer", Pattern: "r1"}}
+	airport.restored = true
+	airport.OnConnect()
+
+	airport.Mutex.RLock()
+	defer airport.Mutex.RUnlock()
+	if airport.GetRetailer("Retailer.r1") != nil {
+		t.Error("banned Retailer.r1 is still connected")
+	}
+	if airport.GetRetailer("Retailer.r2") == nil {
+		t.Error("Retailer.r2 was disconnected")
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Product is something the retailers stock and the passengers order. Its ID
+// is what the events carry in their "offer" field, matched regardless of
+// case.
+type Product struct {
+	ID    string  `json:"id"`
+	Name  string  `json:"name"`            // e.g. "small coffee", the ID if empty
+	Label string  `json:"label,omitempty"` // text of the passenger page's button, the name if empty
+	Icon  string  `json:"icon,omitempty"`  // image URL, relative to the pages
+	Price float64 `json:"price,omitempty"`
+}
+
+// Catalog is the airport's products, in the order they're offered.
+type Catalog []Product
+
+// DefaultCatalog is used when there's no catalog file.
+var DefaultCatalog = Catalog{
+	{ID: "small", Name: "small coffee", Label: "Small", Icon: "images/cup.png", Price: 2},
+	{ID: "medium", Name: "medium coffee", Label: "Medium", Icon: "images/cup.png", Price: 2.5},
+	{ID: "large", Name: "large coffee", Label: "Large", Icon: "images/cup.png", Price: 3},
+}
+
+// The IDs end up in the passenger and view websocket messages as they are
+var productID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Find returns the product the offer names, or nil if there's none.
+func (c Catalog) Find(offer string) *Product {
+	for i := range c {
+		if strings.EqualFold(c[i].ID, offer) {
+			return &c[i]
+		}
+	}
+	return nil
+}
+
+// OfferID returns the ID of the product the offer names, or the offer in
+// lower case if it's not in the catalog.
+func (c Catalog) OfferID(offer string) string {
+	if p := c.Find(offer); p != nil {
+		return p.ID
+	}
+	return strings.ToLower(offer)
+}
+
+// ReadCatalogFile reads a list of products from a YAML or JSON file.
+func ReadCatalogFile(file string) (Catalog, error) {
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var catalog Catalog
+	if err := yaml.Unmarshal(bytes, &catalog); err != nil {
+		return nil, err
+	}
+	if len(catalog) == 0 {
+		return nil, errors.New("no products")
+	}
+
+	seen := map[string]bool{}
+	for i := range catalog {
+		p := &catalog[i]
+		if !productID.MatchString(p.ID) {
+			return nil, errors.New("product ids are letters, digits, '-' and '_': " + p.ID)
+		}
+		if seen[strings.ToLower(p.ID)] {
+			return nil, errors.New("product " + p.ID + " is given twice")
+		}
+		seen[strings.ToLower(p.ID)] = true
+		if p.Price < 0 {
+			return nil, errors.New("product " + p.ID + ": price can't be negative")
+		}
+
+		if p.Name == "" {
+			p.Name = p.ID
+		}
+		if p.Label == "" {
+			p.Label = p.Name
+		}
+	}
+	return catalog, nil
+}
+
+// PublishCatalog tells the participants, or just the one named by subject,
+// what's on offer. The caller must hold the airport lock.
+func (airport *Airport) PublishCatalog(subject string) {
+	body, _ := json.Marshal(airport.Catalog)
+	airport.Publish(&CloudEvent{
+		Type:    "Controller.Catalog",
+		Source:  "Controller",
+		Subject: subject,
+		Data:    body,
+	})
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"mime"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	SpecVersion03 = "0.3"
+	SpecVersion10 = "1.0"
+)
+
+// DefaultSpecVersion is used for outgoing events whose subject hasn't told us
+// which version of the spec it speaks.
+var DefaultSpecVersion = SpecVersion10
+
+// CloudEvent is a CloudEvents 1.0 event. Events from 0.3 participants are
+// read into the same struct and are written back out in 0.3 form when
+// SpecVersion is "0.3".
+type CloudEvent struct {
+	SpecVersion     string
+	Type            string
+	Source          string
+	Subject         string
+	ID              string
+	Time            string
+	DataContentType string
+	DataSchema      string
+	Data            json.RawMessage
+
+	// Cause is the ID of the event this one is a response to. It travels as
+	// the "cause" extension attribute.
+	Cause string
+
+	// Extensions holds every other extension attribute.
+	Extensions map[string]interface{}
+
+	DataObject interface{}
+
+	// ctx is the event's trace context, see Context.
+	ctx context.Context
+}
+
+// coreAttributes are the attributes that are never treated as extensions,
+// in either version of the spec.
+var coreAttributes = map[string]bool{
+	"specversion":         true,
+	"type":                true,
+	"source":              true,
+	"subject":             true,
+	"id":                  true,
+	"time":                true,
+	"datacontenttype":     true,
+	"dataschema":          true,
+	"data":                true,
+	"data_base64":         true,
+	"contenttype":         true,
+	"schemaurl":           true,
+	"datacontentencoding": true,
+	"extensions":          true,
+	"cause":               true,
+}
+
+// SetDefaults fills in the attributes every outgoing event must carry.
+func (event *CloudEvent) SetDefaults() {
+	if event.SpecVersion == "" {
+		event.SpecVersion = DefaultSpecVersion
+	}
+	if event.ID == "" {
+		event.ID = uuid.Must(uuid.NewV4()).String()
+	}
+	if event.Time == "" {
+		event.Time = time.Now().Format(time.RFC3339Nano)
+	}
+}
+
+// SetExtension sets an extension attribute, routing "cause" to its field.
+func (event *CloudEvent) SetExtension(name string, value interface{}) {
+	name = strings.ToLower(name)
+	if name == "cause" {
+		if s, ok := value.(string); ok {
+			event.Cause = s
+		}
+		return
+	}
+
+	if event.Extensions == nil {
+		event.Extensions = map[string]interface{}{}
+	}
+	event.Extensions[name] = value
+}
+
+// SetDefaults fills in the attributes every event the airport publishes
+// must carry, in the spec version its subject speaks.
+func (airport *Airport) SetDefaults(event *CloudEvent) {
+	if event.SpecVersion == "" {
+		event.SpecVersion = airport.SpecVersionFor(event.Subject)
+	}
+	event.SetDefaults()
+}
+
+// SpecVersionFor returns the spec version to use for an event addressed to
+// the named participant.
+func (airport *Airport) SpecVersionFor(name string) string {
+	airport.specVersions_mu.Lock()
+	defer airport.specVersions_mu.Unlock()
+	if v, ok := airport.specVersions[name]; ok {
+		return v
+	}
+	return DefaultSpecVersion
+}
+
+// NegotiateSpecVersion records the spec version used by the source of an
+// inbound event.
+func (airport *Airport) NegotiateSpecVersion(event *CloudEvent) {
+	if event.Source == "" || event.Source == "Controller" {
+		return
+	}
+
+	switch event.SpecVersion {
+	case SpecVersion03, SpecVersion10:
+		airport.specVersions_mu.Lock()
+		airport.specVersions[event.Source] = event.SpecVersion
+		airport.specVersions_mu.Unlock()
+	}
+}
+
+// IsJSONContentType reports whether data of the given content type is
+// carried as JSON rather than as a string or base64.
+func IsJSONContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || mediaType == "text/json" ||
+		strings.HasSuffix(mediaType, "+json")
+}
+
+func (event CloudEvent) MarshalJSON() ([]byte, error) {
+	m := map[string]interface{}{}
+	for k, v := range event.Extensions {
+		m[k] = v
+	}
+	if event.Cause != "" {
+		m["cause"] = event.Cause
+	}
+
+	m["specversion"] = event.SpecVersion
+	m["type"] = event.Type
+	m["source"] = event.Source
+	m["id"] = event.ID
+	if event.Subject != "" {
+		m["subject"] = event.Subject
+	}
+	if event.Time != "" {
+		m["time"] = event.Time
+	}
+
+	contentType, schema := "datacontenttype", "dataschema"
+	if event.SpecVersion == SpecVersion03 {
+		contentType, schema = "contenttype", "schemaurl"
+	}
+	if event.DataContentType != "" {
+		m[contentType] = event.DataContentType
+	}
+	if event.DataSchema != "" {
+		m[schema] = event.DataSchema
+	}
+
+	if len(event.Data) > 0 {
+		if IsJSONContentType(event.DataContentType) && json.Valid(event.Data) {
+			m["data"] = event.Data
+		} else if event.SpecVersion == SpecVersion03 {
+			m["datacontentencoding"] = "base64"
+			m["data"] = base64.StdEncoding.EncodeToString(event.Data)
+		} else {
+			m["data_base64"] = base64.StdEncoding.EncodeToString(event.Data)
+		}
+	}
+
+	return json.Marshal(m)
+}
+
+func (event *CloudEvent) UnmarshalJSON(body []byte) error {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(body, &m); err != nil {
+		return err
+	}
+
+	str := func(name string) string {
+		var s string
+		if raw, ok := m[name]; ok {
+			json.Unmarshal(raw, &s)
+		}
+		return s
+	}
+
+	*event = CloudEvent{
+		SpecVersion: str("specversion"),
+		Type:        str("type"),
+		Source:      str("source"),
+		Subject:     str("subject"),
+		ID:          str("id"),
+		Time:        str("time"),
+		Cause:       str("cause"),
+	}
+
+	if event.SpecVersion == SpecVersion03 {
+		event.DataContentType = str("contenttype")
+		event.DataSchema = str("schemaurl")
+	} else {
+		event.DataContentType = str("datacontenttype")
+		event.DataSchema = str("dataschema")
+	}
+
+	if raw, ok := m["data_base64"]; ok {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return err
+		}
+		data, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return errors.New("invalid data_base64: " + err.Error())
+		}
+		event.Data = data
+	} else if raw, ok := m["data"]; ok && string(raw) != "null" {
+		var s string
+		if str("datacontentencoding") == "base64" {
+			if err := json.Unmarshal(raw, &s); err != nil {
+				return err
+			}
+			data, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return errors.New("invalid base64 data: " + err.Error())
+			}
+			event.Data = data
+		} else if !IsJSONContentType(event.DataContentType) && json.Unmarshal(raw, &s) == nil {
+			event.Data = []byte(s)
+		} else {
+			event.Data = raw
+		}
+	}
+
+	// 0.1 style "extensions" bag, still sent by some older participants
+	if raw, ok := m["extensions"]; ok {
+		var bag map[string]interface{}
+		if json.Unmarshal(raw, &bag) == nil {
+			for k, v := range bag {
+				event.SetExtension(k, v)
+			}
+		}
+	}
+
+	for k, raw := range m {
+		if coreAttributes[k] {
+			continue
+		}
+		var v interface{}
+		if json.Unmarshal(raw, &v) == nil {
+			event.SetExtension(k, v)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"time"
+)
+
+// Duration is a time.Duration written as a string like "25s" in JSON and
+// YAML.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.New("durations are strings, e.g. 25s")
+	}
+	t, err := time.ParseDuration(s)
+	if err != nil || t < 0 {
+		return errors.New("bad duration " + s)
+	}
+	*d = Duration(t)
+	return nil
+}
+
+// Escalation is how a timeout rule deals with a participant that doesn't
+// answer in time: the event is published again up to Retries times, waiting
+// longer after each, then the participant is warned, then it's disconnected.
+// Rules without one disconnect straight away.
+type Escalation struct {
+	Retries  int      `json:"retries,omitempty"`
+	Backoff  Duration `json:"backoff,omitempty"` // wait after the first resend, the rule's timeout if unset
+	Factor   float64  `json:"factor,omitempty"`  // backoff multiplier for each further resend, 2 if unset
+	Warn     bool     `json:"warn,omitempty"`    // publish Controller.TimeoutWarning before disconnecting
+	Grace    Duration `json:"grace,omitempty"`   // wait after the warning, the rule's timeout if unset
+	Cooldown Duration `json:"cooldown,omitempty"`
+}
+
+// backoff is how long to wait after the attempt'th resend, counting from 1.
+func (e *Escalation) backoff(rule *TimeoutEvent, attempt int) time.Duration {
+	d := rule.Timeout
+	if e.Backoff > 0 {
+		d = time.Duration(e.Backoff)
+	}
+	factor := e.Factor
+	if factor <= 0 {
+		factor = 2
+	}
+	return time.Duration(float64(d) * math.Pow(factor, float64(attempt-1)))
+}
+
+func (e *Escalation) grace(rule *TimeoutEvent) time.Duration {
+	if e.Grace > 0 {
+		return time.Duration(e.Grace)
+	}
+	return rule.Timeout
+}
+
+// Expire escalates a timeout whose timer has fired. The caller must hold the
+// airport lock.
+func (ate *ActiveTimeoutEvent) Expire() {
+	airport := ate.airport
+	defer airport.Handling(ate.Event)()
+
+	id := ate.Event.ID
+	if airport.ates[id] != ate {
+		// Answered while the timer was waiting for the lock
+		return
+	}
+
+	if ate.Attempt == 0 && !ate.Warned {
+		airport.RecordTimeout(ate.Target)
+	}
+
+	e := ate.Rule.Escalation
+	if e != nil && ate.Attempt < e.Retries {
+		ate.Attempt++
+		airport.Log().Warn("Resending unanswered event", "target", ate.Target, "attempt", ate.Attempt, "retries", e.Retries)
+		airport.Publish(ate.Event)
+		ate.Arm(e.backoff(&ate.Rule, ate.Attempt))
+		return
+	}
+
+	if e != nil && e.Warn && !ate.Warned {
+		ate.Warned = true
+		ate.Arm(e.grace(&ate.Rule))
+		airport.PublishTimeoutWarning(ate)
+		return
+	}
+
+	delete(airport.ates, id)
+	airport.Log().Warn("Disconnecting unresponsive participant", "target", ate.Target)
+	if e != nil && e.Cooldown > 0 {
+		airport.StartCooldown(ate.Target, time.Duration(e.Cooldown))
+	}
+	order := airport.FindOrder(id)
+	if airport.DisconnectParticipant(ate.Target, id) {
+		airport.RecordDisconnect(ate.Target)
+	}
+
+	// Unless the disconnect has re-routed the order already
+	if ate.Rule.Resend && (order == nil || order.Current(id)) {
+		airport.Publish(ate.Event)
+	}
+}
+
+// PublishTimeoutWarning tells the participant a timeout is waiting on that
+// it's about to be disconnected.
+func (airport *Airport) PublishTimeoutWarning(ate *ActiveTimeoutEvent) {
+	body, _ := json.Marshal(struct {
+		ID         string    `json:"id"`
+		Type       string    `json:"type"`
+		Attempts   int       `json:"attempts"`
+		Disconnect time.Time `json:"disconnect"`
+	}{ate.Event.ID, ate.Event.Type, ate.Attempt, ate.Deadline})
+
+	airport.Log().Warn("Warning participant before disconnecting", "target", ate.Target, "disconnect", ate.Deadline)
+	airport.Publish(&CloudEvent{
+		Type:    "Controller.TimeoutWarning",
+		Source:  "Controller",
+		Subject: ate.Target,
+		Cause:   ate.Event.ID,
+		Data:    body,
+	})
+}
+
+// Cooldown holds the jobs of a supplier or carrier disconnected by a timeout
+// until it reconnects or Until passes. Until then its jobs are handed out to
+// the others as usual.
+type Cooldown struct {
+	Until time.Time       `json:"until"`
+	Jobs  map[string]bool `json:"jobs"` // keys as built by supplierJobKeys or carrierJobKeys
+}
+
+// StartCooldown keeps the jobs of the named supplier or carrier for it for
+// d. The caller must hold the airport lock.
+func (airport *Airport) StartCooldown(name string, d time.Duration) {
+	var jobs map[string]bool
+	if s := airport.GetSupplier(name); s != nil {
+		jobs = supplierJobKeys(s.Jobs)
+	} else if c := airport.GetCarrier(name); c != nil {
+		jobs = carrierJobKeys(c.Jobs)
+	} else {
+		return
+	}
+
+	airport.Log().Info("Keeping jobs for the cooldown", "participant", name, "cooldown", d)
+	airport.cooldowns[name] = &Cooldown{Until: time.Now().Add(d), Jobs: jobs}
+}
+
+// Reclaim hands the items held for workers that have come back within their
+// cooldown back to them, overriding the strategy's choice in assigned. The
+// caller must hold the airport lock.
+func (airport *Airport) Reclaim(items []string, workers []Worker, assigned []int) {
+	now := time.Now()
+	for name, cd := range airport.cooldowns {
+		if now.After(cd.Until) {
+			delete(airport.cooldowns, name)
+		}
+	}
+
+	for w, worker := range workers {
+		cd, ok := airport.cooldowns[worker.Name]
+		if !ok {
+			continue
+		}
+		for i, item := range items {
+			if cd.Jobs[item] {
+				assigned[i] = w
+			}
+		}
+		delete(airport.cooldowns, worker.Name)
+		airport.Log().Info("Back within its cooldown, returning its jobs", "participant", worker.Name)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// JobDelta is the data of the Offer.Product.Delta and
+// Offer.Service.Transport.Delta events, published alongside the full job
+// list to tell a participant what changed since the last one it was sent.
+type JobDelta struct {
+	Added   interface{} `json:"added"`
+	Removed interface{} `json:"removed"`
+}
+
+func supplierJobKeys(jobs []*SupplierJob) map[string]bool {
+	keys := map[string]bool{}
+	for _, j := range jobs {
+		for _, o := range j.Offers {
+			keys[j.Retailer+"|"+o] = true
+		}
+	}
+	return keys
+}
+
+func supplierJobsFromKeys(keys []string) []*SupplierJob {
+	jobs := []*SupplierJob{}
+	index := map[string]*SupplierJob{}
+	for _, k := range keys {
+		parts := strings.SplitN(k, "|", 2)
+		if j, ok := index[parts[0]]; ok {
+			j.Offers = append(j.Offers, parts[1])
+			continue
+		}
+		j := &SupplierJob{Retailer: parts[0], Offers: []string{parts[1]}}
+		index[parts[0]] = j
+		jobs = append(jobs, j)
+	}
+	return jobs
+}
+
+func carrierJobKeys(jobs []*CarrierJob) map[string]bool {
+	keys := map[string]bool{}
+	for _, j := range jobs {
+		keys[j.Retailer+"|"+j.Supplier] = true
+	}
+	return keys
+}
+
+func carrierJobsFromKeys(keys []string) []*CarrierJob {
+	jobs := []*CarrierJob{}
+	for _, k := range keys {
+		parts := strings.SplitN(k, "|", 2)
+		jobs = append(jobs, &CarrierJob{Retailer: parts[0], Supplier: parts[1]})
+	}
+	return jobs
+}
+
+// diffJobs returns the keys in next but not in prev, and those in prev but
+// not in next. Added keys keep the order given by order.
+func diffJobs(prev, next map[string]bool, order []string) (added, removed []string) {
+	for _, k := range order {
+		if next[k] && !prev[k] {
+			added = append(added, k)
+		}
+	}
+	for k := range prev {
+		if !next[k] {
+			removed = append(removed, k)
+		}
+	}
+	sort.Strings(removed)
+	return added, removed
+}
+
+// SyncJobs publishes the supplier's jobs, and what changed in them, if they
+// differ from what it was last sent.
+func (supplier *Supplier) SyncJobs() {
+	next := supplierJobKeys(supplier.Jobs)
+	if supplier.sent != nil && sameKeys(supplier.sent, next) {
+		return
+	}
+
+	var order []string
+	for _, j := range supplier.Jobs {
+		for _, o := range j.Offers {
+			order = append(order, j.Retailer+"|"+o)
+		}
+	}
+	added, removed := diffJobs(supplier.sent, next, order)
+
+	supplier.UpdateJob()
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+
+	body, _ := json.Marshal(JobDelta{
+		Added:   supplierJobsFromKeys(added),
+		Removed: supplierJobsFromKeys(removed),
+	})
+	supplier.airport.Publish(&CloudEvent{
+		Type:    "Offer.Product.Delta",
+		Source:  "Controller",
+		Subject: supplier.Name,
+		Data:    body,
+	})
+}
+
+// SyncJobs publishes the carrier's jobs, and what changed in them, if they
+// differ from what it was last sent.
+func (carrier *Carrier) SyncJobs() {
+	next := carrierJobKeys(carrier.Jobs)
+	if carrier.sent != nil && sameKeys(carrier.sent, next) {
+		return
+	}
+
+	var order []string
+	for _, j := range carrier.Jobs {
+		order = append(order, j.Retailer+"|"+j.Supplier)
+	}
+	added, removed := diffJobs(carrier.sent, next, order)
+
+	carrier.UpdateJob()
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+
+	body, _ := json.Marshal(JobDelta{
+		Added:   carrierJobsFromKeys(added),
+		Removed: carrierJobsFromKeys(removed),
+	})
+	carrier.airport.Publish(&CloudEvent{
+		Type:    "Offer.Service.Transport.Delta",
+		Source:  "Controller",
+		Subject: carrier.Name,
+		Data:    body,
+	})
+}
+
+func sameKeys(a, b map[string]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if !b[k] {
+			return false
+		}
+	}
+	return true
+}
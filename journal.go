@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	JOURNAL_IN  = "in"
+	JOURNAL_OUT = "out"
+)
+
+// JournalEntry is one line of the journal.
+type JournalEntry struct {
+	Time      time.Time   `json:"time"`
+	Airport   string      `json:"airport,omitempty"`
+	Direction string      `json:"direction"`
+	Event     *CloudEvent `json:"event"`
+}
+
+// Journal is an append-only JSON lines record of every event the controller
+// receives and publishes.
+type Journal struct {
+	mutex   sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+var journal *Journal
+
+// replaySpeed scales the watchdog timeouts and the controller's own delays
+// so an accelerated replay disconnects participants, and moves customers and
+// carriers along, at the same point of the recording as live.
+var replaySpeed = 1.0
+
+// scaled returns how long d of the recording lasts at the replay speed.
+func scaled(d time.Duration) time.Duration {
+	if replaySpeed > 0 {
+		return time.Duration(float64(d) / replaySpeed)
+	}
+	return d
+}
+
+func OpenJournal(path string) (*Journal, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// Close closes the journal. It does nothing if there's no journal.
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.file.Close()
+}
+
+// Record appends an event of the named airport to the journal. It does
+// nothing if there's no journal.
+func (j *Journal) Record(airport string, direction string, event *CloudEvent) {
+	if j == nil {
+		return
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	err := j.encoder.Encode(JournalEntry{
+		Time:      time.Now(),
+		Airport:   airport,
+		Direction: direction,
+		Event:     event,
+	})
+	if err != nil {
+		slog.Error("Error writing journal", EventAttr(event), "err", err)
+	}
+}
+
+// ReplayTransport is a Transport that feeds the inbound events of one
+// airport in a journal back to the controller, keeping their original
+// spacing divided by Speed. A Speed of 0 replays as fast as possible.
+// Published events go nowhere.
+type ReplayTransport struct {
+	Path    string
+	Speed   float64
+	Airport string
+}
+
+func (t *ReplayTransport) Send(event *CloudEvent) error {
+	return nil
+}
+
+func (t *ReplayTransport) Close() error {
+	return nil
+}
+
+func (t *ReplayTransport) Listen(ctx context.Context, onConnect func(), handler func(*CloudEvent)) {
+	file, err := os.Open(t.Path)
+	if err != nil {
+		fatal("Error opening journal", "path", t.Path, "err", err)
+	}
+	defer file.Close()
+
+	onConnect()
+
+	slog.Info("Replaying journal", "airport", t.Airport, "path", t.Path, "speed", t.Speed)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	var last time.Time
+	count := 0
+	for ctx.Err() == nil && scanner.Scan() {
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			slog.Warn("Skipping bad journal line", "err", err)
+			continue
+		}
+
+		// What the controller published came back to it as inbound events,
+		// so replaying the inbound side alone reproduces the session.
+		if entry.Direction != JOURNAL_IN || entry.Event == nil || entry.Airport != t.Airport {
+			continue
+		}
+
+		if !last.IsZero() && t.Speed > 0 {
+			select {
+			case <-time.After(time.Duration(float64(entry.Time.Sub(last)) / t.Speed)):
+			case <-ctx.Done():
+				return
+			}
+		}
+		last = entry.Time
+
+		handler(entry.Event)
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		slog.Error("Error reading journal", "path", t.Path, "err", err)
+	}
+
+	slog.Info("Replay finished", "airport", t.Airport, "path", t.Path, "events", count)
+	<-ctx.Done()
+}
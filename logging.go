@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+)
+
+// EventAttr groups the fields identifying an event for a log line.
+func EventAttr(event *CloudEvent) slog.Attr {
+	attrs := []any{
+		slog.String("id", event.ID),
+		slog.String("type", event.Type),
+		slog.String("source", event.Source),
+	}
+	if event.Subject != "" {
+		attrs = append(attrs, slog.String("subject", event.Subject))
+	}
+	if event.Cause != "" {
+		attrs = append(attrs, slog.String("cause", event.Cause))
+	}
+	return slog.Group("event", attrs...)
+}
+
+// Log returns the logger for whatever is being done under the airport lock:
+// its lines carry the airport's name, unless it's the default airport, and
+// the fields of the event being handled, if any. The caller must hold the
+// airport lock.
+func (airport *Airport) Log() *slog.Logger {
+	logger := slog.Default()
+	if airport.Name != "" {
+		logger = logger.With("airport", airport.Name)
+	}
+	if airport.logEvent != nil {
+		logger = logger.With(EventAttr(airport.logEvent))
+	}
+	return logger
+}
+
+// Handling makes the lines written through Log carry the event's fields
+// until the returned func is called. The caller must hold the airport lock.
+func (airport *Airport) Handling(event *CloudEvent) func() {
+	prev := airport.logEvent
+	airport.logEvent = event
+	return func() { airport.logEvent = prev }
+}
+
+// SetupLogging makes the default logger write lines at level and above, as
+// text or JSON, to stderr.
+func SetupLogging(level, format string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return errors.New("unknown log level " + level + ", use debug, info, warn or error")
+	}
+
+	opts := &slog.HandlerOptions{Level: l}
+	switch format {
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, opts)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, opts)))
+	default:
+		return errors.New("unknown log format " + format + ", use text or json")
+	}
+	return nil
+}
+
+// fatal logs msg as an error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var (
+	eventsProcessed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "airport_events_total",
+		Help: "Events processed, by airport, type and the role of their source. Banned and rejected events aren't counted.",
+	}, []string{"airport", "type", "role"})
+
+	amqpReconnects = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "airport_amqp_reconnects_total",
+		Help: "Times the AMQP connection was established again after the first.",
+	})
+
+	broadcastDrops = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "airport_broadcast_drops_total",
+		Help: "View messages dropped because a view's queue was full, by airport.",
+	}, []string{"airport"})
+
+	restocksOrdered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "airport_restocks_total",
+		Help: "Restocks the controller ordered for retailers below their low-water mark, by airport.",
+	}, []string{"airport"})
+)
+
+var (
+	participantsDesc = prometheus.NewDesc("airport_participants",
+		"Connected participants, by airport and role.", []string{"airport", "role"}, nil)
+	customersDesc = prometheus.NewDesc("airport_customers",
+		"Customers in the airport, by airport and state.", []string{"airport", "state"}, nil)
+	timeoutsDesc = prometheus.NewDesc("airport_active_timeouts",
+		"Timeouts waiting on a response, by airport.", []string{"airport"}, nil)
+	viewClientsDesc = prometheus.NewDesc("airport_view_clients",
+		"Connected view websockets, by airport.", []string{"airport"}, nil)
+)
+
+// CustomerStates names the CUSTOMER_* constants, in the same order.
+var CustomerStates = []string{"walking", "inline", "ordering", "ordered", "satisfied"}
+
+// airportCollector reads the gauges off the airports when scraped.
+type airportCollector struct{}
+
+func (airportCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- participantsDesc
+	ch <- customersDesc
+	ch <- timeoutsDesc
+	ch <- viewClientsDesc
+}
+
+func (airportCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, airport := range airports {
+		airport.collect(ch)
+	}
+}
+
+func (airport *Airport) collect(ch chan<- prometheus.Metric) {
+	name := airport.Name
+	airport.Mutex.RLock()
+	ch <- prometheus.MustNewConstMetric(participantsDesc, prometheus.GaugeValue, float64(len(airport.Retailers)), name, "Retailer")
+	ch <- prometheus.MustNewConstMetric(participantsDesc, prometheus.GaugeValue, float64(len(airport.Suppliers)), name, "Supplier")
+	ch <- prometheus.MustNewConstMetric(participantsDesc, prometheus.GaugeValue, float64(len(airport.Carriers)), name, "Carrier")
+
+	counts := make([]int, len(CustomerStates))
+	for _, r := range airport.Retailers {
+		for _, c := range r.Customers {
+			if c.State >= 0 && c.State < len(counts) {
+				counts[c.State]++
+			}
+		}
+	}
+	for i, n := range counts {
+		ch <- prometheus.MustNewConstMetric(customersDesc, prometheus.GaugeValue, float64(n), name, CustomerStates[i])
+	}
+
+	ch <- prometheus.MustNewConstMetric(timeoutsDesc, prometheus.GaugeValue, float64(len(airport.ates)), name)
+	airport.Mutex.RUnlock()
+
+	airport.clients_mu.Lock()
+	ch <- prometheus.MustNewConstMetric(viewClientsDesc, prometheus.GaugeValue, float64(len(airport.clients)), name)
+	airport.clients_mu.Unlock()
+}
+
+// airportGatherer gathers the controller's metrics less the ones labelled
+// with another airport's name.
+type airportGatherer struct {
+	name string
+}
+
+func (g airportGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := prometheus.DefaultGatherer.Gather()
+	kept := families[:0]
+	for _, family := range families {
+		metrics := family.Metric[:0]
+		for _, m := range family.Metric {
+			if name, ok := airportLabel(m); !ok || name == g.name {
+				metrics = append(metrics, m)
+			}
+		}
+		if family.Metric = metrics; len(metrics) > 0 {
+			kept = append(kept, family)
+		}
+	}
+	return kept, err
+}
+
+func airportLabel(m *dto.Metric) (string, bool) {
+	for _, label := range m.Label {
+		if label.GetName() == "airport" {
+			return label.GetValue(), true
+		}
+	}
+	return "", false
+}
+
+// MetricsHandler serves the airport's metrics. The default airport's are the
+// whole controller's, every airport's included.
+func (airport *Airport) MetricsHandler() http.Handler {
+	if airport.Name == "" {
+		return promhttp.Handler()
+	}
+	return promhttp.HandlerFor(airportGatherer{name: airport.Name}, promhttp.HandlerOpts{})
+}
+
+func init() {
+	prometheus.MustRegister(eventsProcessed, amqpReconnects, broadcastDrops, restocksOrdered, airportCollector{})
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	ORDER_RETAILER = iota // Passenger ordered, waiting on the retailer
+	ORDER_SUPPLIER = iota // Retailer restocking, waiting on the supplier
+	ORDER_CARRIER  = iota // Supplier has it ready, waiting on the carrier
+)
+
+// orderTTL is how long an order is tracked for at most.
+const orderTTL = 5 * time.Minute
+
+// orderSweep is how often the orders are re-routed and dropped besides
+// whenever the jobs change, see SweepOrders.
+const orderSweep = 30 * time.Second
+
+// finishedOrders is how many finished orders are kept for /orders.
+const finishedOrders = 100
+
+// Order follows one coffee through the choreography, from a passenger's
+// order or a retailer's restock request to its delivery, so we can see where
+// it's stuck and hand it to whoever takes over when the participant
+// responsible for it goes away.
+type Order struct {
+	ID          string            `json:"id"` // the customer's ID, or the first event's for a restock
+	Customer    string            `json:"customer,omitempty"`
+	Retailer    string            `json:"retailer"`
+	Offer       string            `json:"offer"`
+	Supplier    string            `json:"supplier,omitempty"`
+	Carrier     string            `json:"carrier,omitempty"`
+	Stage       int               `json:"stage"`
+	Status      string            `json:"status"`
+	Responsible string            `json:"responsible,omitempty"`
+	Started     time.Time         `json:"started"`
+	Finished    *time.Time        `json:"finished,omitempty"`
+	Auto        bool              `json:"auto,omitempty"` // a restock the controller ordered, see Restock
+	History     []OrderTransition `json:"history"`
+
+	Request *CloudEvent `json:"-"` // the retailer's OrderReleased
+	Pickup  *CloudEvent `json:"-"` // the supplier's PotentialActionStatus
+
+	ids     map[string]bool // every event that's part of the order
+	airport *Airport
+}
+
+// OrderTransition is a change in an order's status.
+type OrderTransition struct {
+	Time        time.Time `json:"time"`
+	Status      string    `json:"status"`
+	Responsible string    `json:"responsible,omitempty"` // who the order is waiting on afterwards
+	Event       string    `json:"event,omitempty"`
+}
+
+func (airport *Airport) newOrder(id string) *Order {
+	o := &Order{ID: id, Started: time.Now(), airport: airport}
+	airport.orders = append(airport.orders, o)
+	return o
+}
+
+// transition records the order's new status, caused by event if not nil.
+func (o *Order) transition(status string, event *CloudEvent) {
+	switch o.Stage {
+	case ORDER_RETAILER:
+		o.Responsible = o.Retailer
+	case ORDER_SUPPLIER:
+		o.Responsible = o.Supplier
+	case ORDER_CARRIER:
+		o.Responsible = o.Carrier
+	}
+	o.Status = status
+	o.airport.Log().Debug("Order status", "order", o.ID, "status", status, "responsible", o.Responsible)
+
+	t := OrderTransition{Time: time.Now(), Status: status, Responsible: o.Responsible}
+	if event != nil {
+		if o.ids == nil {
+			o.ids = map[string]bool{}
+		}
+		o.ids[event.ID] = true
+		t.Event = event.ID
+	}
+	o.History = append(o.History, t)
+}
+
+// finish records the order's last status and moves it to the finished ones.
+func (o *Order) finish(status string, event *CloudEvent) {
+	airport := o.airport
+	o.Stage = -1
+	o.transition(status, event)
+	o.Responsible = ""
+	now := time.Now()
+	o.Finished = &now
+
+	for i, order := range airport.orders {
+		if order == o {
+			airport.orders = append(airport.orders[:i], airport.orders[i+1:]...)
+			break
+		}
+	}
+	airport.finished = append(airport.finished, o)
+	if len(airport.finished) > finishedOrders {
+		airport.finished = airport.finished[len(airport.finished)-finishedOrders:]
+	}
+}
+
+func sameOffer(a, b string) bool {
+	return a == "" || b == "" || strings.EqualFold(a, b)
+}
+
+// followOrder returns the order the event follows on from, by its cause or,
+// failing that, by the first order at stage that match accepts.
+func (airport *Airport) followOrder(event *CloudEvent, stage int, match func(*Order) bool) *Order {
+	if event.Cause != "" {
+		if o := airport.FindOrder(event.Cause); o != nil {
+			return o
+		}
+	}
+	for _, o := range airport.orders {
+		if o.Stage == stage && match(o) {
+			return o
+		}
+	}
+	return nil
+}
+
+// FindOrder returns the order in progress the event is part of, if any. The
+// caller must hold the airport lock.
+func (airport *Airport) FindOrder(id string) *Order {
+	for _, o := range airport.orders {
+		if o.ids[id] {
+			return o
+		}
+	}
+	return nil
+}
+
+// Current reports whether id is still the event the order's supplier or
+// carrier has to act on, i.e. it hasn't been re-routed.
+func (o *Order) Current(id string) bool {
+	return (o.Request != nil && o.Request.ID == id) || (o.Pickup != nil && o.Pickup.ID == id)
+}
+
+// supplierFor returns the supplier whose jobs include the retailer's offer.
+func (airport *Airport) supplierFor(retailer, offer string) string {
+	for _, s := range airport.Suppliers {
+		for _, j := range s.Jobs {
+			if j.Retailer != retailer {
+				continue
+			}
+			for _, o := range j.Offers {
+				if sameOffer(o, offer) {
+					return s.Name
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// carrierFor returns the carrier whose jobs include taking the supplier's
+// goods to the retailer.
+func (airport *Airport) carrierFor(retailer, supplier string) string {
+	for _, c := range airport.Carriers {
+		for _, j := range c.Jobs {
+			if j.Retailer == retailer && j.Supplier == supplier {
+				return c.Name
+			}
+		}
+	}
+	return ""
+}
+
+// TrackOrder moves the orders along as their events go by. The caller must
+// hold the airport lock.
+func (airport *Airport) TrackOrder(event *CloudEvent) {
+	if airport.FindOrder(event.ID) != nil {
+		// Already seen, e.g. a re-routed event coming back
+		return
+	}
+
+	var data struct {
+		Provider     string `json:"provider"`
+		Customer     string `json:"customer"`
+		OrderStatus  string `json:"orderStatus"`
+		ActionStatus string `json:"actionStatus"`
+		FromLocation string `json:"fromLocation"`
+		ToLocation   string `json:"toLocation"`
+		Offer        string `json:"offer"`
+	}
+	if json.Unmarshal(event.Data, &data) != nil {
+		return
+	}
+
+	role := strings.Split(event.Source, ".")[0]
+	switch {
+	case role == "Passenger" && data.OrderStatus == "OrderReleased":
+		o := airport.newOrder(strings.TrimPrefix(data.Customer, "Customer."))
+		o.Customer = data.Customer
+		o.Retailer = data.Provider
+		o.Offer = data.Offer
+		o.Stage = ORDER_RETAILER
+		o.transition("Ordered", event)
+
+	case role == "Retailer" && data.OrderStatus == "OrderReleased":
+		// Restocking, most likely for the customer waiting on the offer
+		o := airport.followOrder(event, ORDER_RETAILER, func(o *Order) bool {
+			return o.Retailer == event.Source && sameOffer(o.Offer, data.Offer)
+		})
+		if o == nil {
+			o = airport.newOrder(event.ID)
+			o.Retailer = event.Source
+		}
+		o.Offer = data.Offer
+		o.Stage = ORDER_SUPPLIER
+		o.Request = event
+		o.Supplier = airport.supplierFor(o.Retailer, o.Offer)
+		o.transition("Restocking", event)
+
+	case role == "Retailer" && data.OrderStatus == "OrderDelivered":
+		for _, o := range airport.orders {
+			if o.Customer != "" && o.Customer == event.Subject {
+				o.finish("Delivered", event)
+				break
+			}
+		}
+
+	case role == "Supplier" && data.ActionStatus == "PotentialActionStatus":
+		o := airport.followOrder(event, ORDER_SUPPLIER, func(o *Order) bool {
+			return o.Retailer == data.ToLocation && sameOffer(o.Offer, data.Offer)
+		})
+		if o == nil {
+			o = airport.newOrder(event.ID)
+			o.Retailer = data.ToLocation
+			o.Offer = data.Offer
+		}
+		o.Supplier = event.Source
+		o.Stage = ORDER_CARRIER
+		o.Pickup = event
+		o.Carrier = airport.carrierFor(o.Retailer, o.Supplier)
+		o.transition("ReadyForPickup", event)
+
+	case data.ActionStatus == "ActiveActionStatus" || data.ActionStatus == "ArrivedActionStatus":
+		o := airport.followOrder(event, ORDER_CARRIER, func(o *Order) bool {
+			return o.Retailer == data.ToLocation && o.Supplier == data.FromLocation && sameOffer(o.Offer, data.Offer)
+		})
+		if o == nil {
+			break
+		}
+		if role == "Carrier" {
+			o.Carrier = event.Source
+			o.transition("PickedUp", event)
+		} else {
+			o.transition("Arrived", event)
+		}
+
+	case role == "Carrier" && data.ActionStatus == "CompletedActionStatus":
+		o := airport.followOrder(event, ORDER_CARRIER, func(o *Order) bool {
+			return o.Retailer == data.ToLocation && o.Carrier == event.Source && sameOffer(o.Offer, data.Offer)
+		})
+		if o == nil {
+			break
+		}
+		if o.Customer == "" {
+			o.finish("Transferred", event)
+			break
+		}
+		o.Stage = ORDER_RETAILER
+		o.transition("Transferred", event)
+	}
+}
+
+// CustomerLeft records that a customer has gone, with or without their
+// coffee. An order still waiting on the retailer is finished; one further
+// along carries on as a restock. The caller must hold the airport lock.
+func (airport *Airport) CustomerLeft(customer *Customer) {
+	for _, o := range airport.orders {
+		if o.Customer != "Customer."+customer.Id {
+			continue
+		}
+		if o.Stage == ORDER_RETAILER {
+			o.finish("Abandoned", nil)
+		} else {
+			o.transition("Abandoned", nil)
+			o.Customer = ""
+		}
+		return
+	}
+}
+
+// RerouteOrders hands the orders whose supplier or carrier has gone to the
+// participant now responsible for them, with a fresh copy of the event it has
+// to act on, and drops the orders that can't go anywhere any more. The
+// caller must hold the airport lock.
+func (airport *Airport) RerouteOrders() {
+	now := time.Now()
+	for _, o := range append([]*Order{}, airport.orders...) {
+		if airport.GetRetailer(o.Retailer) == nil || now.Sub(o.Started) > orderTTL {
+			o.finish("Dropped", nil)
+			continue
+		}
+
+		switch o.Stage {
+		case ORDER_CARRIER:
+			if airport.GetCarrier(o.Carrier) != nil {
+				break
+			}
+			if airport.GetSupplier(o.Supplier) != nil {
+				if c := airport.carrierFor(o.Retailer, o.Supplier); c != "" && o.Pickup != nil {
+					o.Carrier = c
+					o.Pickup = o.resend(o.Pickup, c)
+				}
+				break
+			}
+			if o.Request == nil {
+				break
+			}
+			// The goods went with the supplier, start over with another
+			o.Stage, o.Carrier = ORDER_SUPPLIER, ""
+			fallthrough
+		case ORDER_SUPPLIER:
+			if airport.GetSupplier(o.Supplier) != nil {
+				break
+			}
+			if s := airport.supplierFor(o.Retailer, o.Offer); s != "" && o.Request != nil {
+				o.Supplier = s
+				o.Request = o.resend(o.Request, s)
+			}
+		}
+	}
+}
+
+// SweepOrders re-routes and drops orders every orderSweep until ctx is done,
+// so an order past orderTTL goes even if the jobs never change again.
+func (airport *Airport) SweepOrders(ctx context.Context) {
+	ticker := time.NewTicker(orderSweep)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		airport.Mutex.Lock()
+		if !airport.stopping {
+			airport.RerouteOrders()
+		}
+		airport.Mutex.Unlock()
+	}
+}
+
+// resend publishes a copy of event, with a new ID, for the participant to
+// act on in place of the one that went away, and returns it. The copy comes
+// from the controller, keeps the event's subject and names the participant
+// in the "target" extension and the event's source in "origin". A timeout
+// watching the event watches the copy instead, waiting on the participant.
+func (o *Order) resend(event *CloudEvent, to string) *CloudEvent {
+	airport := o.airport
+	ate, watched := airport.ates[event.ID]
+	if watched {
+		ate.Timer.Stop()
+		delete(airport.ates, event.ID)
+	}
+
+	fresh := *event
+	fresh.ID, fresh.Time, fresh.SpecVersion = "", "", ""
+	fresh.Source = "Controller"
+	fresh.Cause = event.ID
+	fresh.Extensions = map[string]interface{}{}
+	for k, v := range event.Extensions {
+		fresh.Extensions[k] = v
+	}
+	fresh.SetExtension("target", to)
+	fresh.SetExtension("origin", event.Source)
+	airport.SetDefaults(&fresh)
+	o.transition("Rerouted", &fresh)
+
+	if watched {
+		airport.StartTimeout(&fresh, ate.Rule, to, ate.Rule.Timeout)
+	}
+
+	airport.Log().Info("Re-routing order", "order", o.ID, "to", to, "replaces", event.ID, "with", fresh.ID)
+	airport.Publish(&fresh)
+	return &fresh
+}
+
+// GET /orders lists the orders in progress followed by the finished ones.
+// GET /orders/{id} shows one, id being the customer's ID.
+func (airport *Airport) HandleOrders(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	airport.Mutex.RLock()
+	defer airport.Mutex.RUnlock()
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/orders"), "/")
+	if id == "" {
+		writeJSON(w, append(append([]*Order{}, airport.orders...), airport.finished...))
+		return
+	}
+
+	for _, list := range [][]*Order{airport.orders, airport.finished} {
+		for i := len(list) - 1; i >= 0; i-- {
+			if list[i].ID == id {
+				writeJSON(w, list[i])
+				return
+			}
+		}
+	}
+	writeError(w, http.StatusNotFound, "no order "+id)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResendFromController(t *testing.T) {
+	airport := NewAirport("orderstest", NewChannelTransport(), RoundRobin{})
+	defer delete(airports, airport.Name)
+
+	request := &CloudEvent{
+		ID:         "request",
+		Type:       "Order.OrderStatus.OrderReleased",
+		Source:     "Retailer.r1",
+		Subject:    "Customer.1",
+		Extensions: map[string]interface{}{"team": "r"},
+	}
+	airport.Mutex.Lock()
+	defer airport.Mutex.Unlock()
+	o := airport.newOrder(request.ID)
+	o.Request = request
+	ate := airport.StartTimeout(request, TimeoutEvent{Name: "retailer-order", Timeout: time.Hour}, "Supplier.s1", time.Hour)
+	defer ate.Timer.Stop()
+
+	fresh := o.resend(request, "Supplier.s2")
+	if moved := airport.ates[fresh.ID]; moved != nil {
+		defer moved.Timer.Stop()
+	}
+
+	if fresh.Source != "Controller" || fresh.Subject != request.Subject || fresh.Cause != request.ID {
+		t.Errorf("re-routed as %s to %s caused by %s, want Controller to %s caused by %s",
+			fresh.Source, fresh.Subject, fresh.Cause, request.Subject, request.ID)
+	}
+	if fresh.Extensions["target"] != "Supplier.s2" || fresh.Extensions["origin"] != "Retailer.r1" {
+		t.Errorf("target %v and origin %v, want Supplier.s2 and Retailer.r1", fresh.Extensions["target"], fresh.Extensions["origin"])
+	}
+	if _, ok := request.Extensions["target"]; ok {
+		t.Error("the copy's extensions are the original's")
+	}
+
+	if airport.ates[request.ID] != nil {
+		t.Error("the original is still watched")
+	}
+	if moved := airport.ates[fresh.ID]; moved == nil || moved.Target != "Supplier.s2" {
+		t.Error("the copy isn't watched for the new supplier")
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// RestockRule sets the low-water mark below which the controller restocks a
+// retailer's product itself, so a retailer that never orders from its
+// suppliers still gets deliveries. Retailer is a glob matched against the
+// retailer's name, the part of its source after "Retailer.", and Offer a
+// product ID; an empty one matches everything. A LowWater of 0 never
+// restocks, which is how a rule exempts a retailer from the ones after it.
+type RestockRule struct {
+	Retailer string `json:"retailer,omitempty"`
+	Offer    string `json:"offer,omitempty"`
+	LowWater int    `json:"lowWater"`
+}
+
+// Matches reports whether the rule applies to the retailer's product.
+func (rule RestockRule) Matches(retailer string, offer string) bool {
+	name := strings.TrimPrefix(retailer, "Retailer.")
+	if ok, _ := path.Match(rule.Retailer, name); rule.Retailer != "" && !ok {
+		return false
+	}
+	return rule.Offer == "" || strings.EqualFold(rule.Offer, offer)
+}
+
+// LowWater returns the inventory level below which the retailer's product is
+// restocked, 0 if it isn't. The first of the airport's rules that matches
+// applies.
+func (airport *Airport) LowWater(retailer string, offer string) int {
+	airport.rules_mu.RLock()
+	defer airport.rules_mu.RUnlock()
+	for _, rule := range airport.restockRules {
+		if rule.Matches(retailer, offer) {
+			return rule.LowWater
+		}
+	}
+	return 0
+}
+
+// ReadRestockFile reads a list of rules from a YAML or JSON file.
+func ReadRestockFile(file string) ([]RestockRule, error) {
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []RestockRule
+	if err := yaml.Unmarshal(bytes, &rules); err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		if _, err := path.Match(rule.Retailer, ""); err != nil {
+			return nil, errors.New("bad retailer pattern " + rule.Retailer)
+		}
+		if rule.LowWater < 0 {
+			return nil, errors.New("lowWater can't be negative")
+		}
+	}
+	return rules, nil
+}
+
+// LoadRestockFile replaces the airport's rules with the contents of the
+// restock file, or with none if there's no such file. A file with errors
+// leaves the rules as they are.
+func (airport *Airport) LoadRestockFile(file string) {
+	rules, err := ReadRestockFile(file)
+	switch {
+	case os.IsNotExist(err):
+		slog.Info("No restock file, leaving restocking to the retailers", "airport", airport.Name, "file", file)
+	case err != nil:
+		slog.Error("Error reading restock file", "airport", airport.Name, "file", file, "err", err)
+		return
+	default:
+		slog.Info("Loaded restock rules", "airport", airport.Name, "file", file, "rules", len(rules))
+	}
+
+	airport.rules_mu.Lock()
+	airport.restockRules = rules
+	airport.rules_mu.Unlock()
+}
+
+// Restock orders the product for the retailer from the supplier with the job
+// for it once its inventory is below the low-water mark, unless a restock is
+// on its way already. The order is tracked like the retailer's own. The
+// caller must hold the airport lock.
+func (airport *Airport) Restock(retailer *Retailer, offer string) {
+	level, known := retailer.Offers[offer]
+	if !known || !retailer.Sells(offer) || level >= airport.LowWater(retailer.Name, offer) {
+		return
+	}
+
+	for _, o := range airport.orders {
+		if o.Retailer == retailer.Name && o.Stage != ORDER_RETAILER && sameOffer(o.Offer, offer) {
+			return
+		}
+	}
+
+	supplier := airport.supplierFor(retailer.Name, offer)
+	if supplier == "" {
+		airport.Log().Debug("No supplier to restock from", "participant", retailer.Name, "offer", offer)
+		return
+	}
+
+	body, _ := json.Marshal(map[string]string{
+		"orderStatus": "OrderReleased",
+		"customer":    retailer.Name,
+		"offer":       offer,
+	})
+	event := &CloudEvent{
+		Type:    "Order.OrderStatus.OrderReleased",
+		Source:  "Controller",
+		Subject: supplier,
+		Data:    body,
+	}
+	airport.SetDefaults(event)
+
+	o := airport.newOrder(event.ID)
+	o.Retailer = retailer.Name
+	o.Offer = offer
+	o.Supplier = supplier
+	o.Stage = ORDER_SUPPLIER
+	o.Request = event
+	o.Auto = true
+	o.transition("Restocking", event)
+
+	airport.Log().Info("Restocking", "participant", retailer.Name, "offer", offer, "level", level, "supplier", supplier)
+	restocksOrdered.WithLabelValues(airport.Name).Inc()
+	airport.Publish(event)
+}
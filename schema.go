@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// EventSchemas has the JSON Schema for the data of every event the
+// controller consumes, keyed by "Role Type" where Role is the first part of
+// the event's source.
+var EventSchemas = map[string]string{
+	"Retailer Connection": `{
+		"type": "object",
+		"properties": {
+			"organization": {"type": "string"},
+			"logo": {"type": "string"},
+			"menu": {"type": "array", "items": {"type": "string", "minLength": 1}}
+		}
+	}`,
+	"Supplier Connection": `{
+		"type": "object",
+		"properties": {
+			"logo": {"type": "string"},
+			"capacity": {"type": "integer", "minimum": 1}
+		}
+	}`,
+	"Carrier Connection": `{
+		"type": "object",
+		"properties": {
+			"logo": {"type": "string"},
+			"capacity": {"type": "integer", "minimum": 1}
+		}
+	}`,
+	"Retailer Offer.InventoryLevel": `{
+		"type": "object",
+		"properties": {
+			"inventoryLevel": {"type": "integer", "minimum": 0},
+			"offer": {"type": "string", "minLength": 1}
+		},
+		"required": ["inventoryLevel", "offer"]
+	}`,
+	"Retailer Order.OrderStatus.OrderReleased": `{
+		"type": "object",
+		"properties": {
+			"orderStatus": {"const": "OrderReleased"},
+			"offer": {"type": "string", "minLength": 1}
+		},
+		"required": ["orderStatus", "offer"]
+	}`,
+	"Retailer Order.OrderStatus.OrderDelivered": `{
+		"type": "object",
+		"properties": {
+			"orderStatus": {"const": "OrderDelivered"},
+			"offer": {"type": "string"}
+		},
+		"required": ["orderStatus"]
+	}`,
+	"Supplier TransferAction.ActionStatus.PotentialActionStatus": `{
+		"type": "object",
+		"properties": {
+			"actionStatus": {"const": "PotentialActionStatus"},
+			"fromLocation": {"type": "string", "minLength": 1},
+			"toLocation": {"type": "string", "minLength": 1},
+			"offer": {"type": "string"}
+		},
+		"required": ["actionStatus", "fromLocation", "toLocation"]
+	}`,
+	"Carrier TransferAction.ActionStatus.ActiveActionStatus": `{
+		"type": "object",
+		"properties": {
+			"actionStatus": {"const": "ActiveActionStatus"},
+			"fromLocation": {"type": "string", "minLength": 1},
+			"toLocation": {"type": "string", "minLength": 1},
+			"offer": {"type": "string"}
+		},
+		"required": ["actionStatus", "fromLocation", "toLocation"]
+	}`,
+	"Carrier TransferAction.ActionStatus.CompletedActionStatus": `{
+		"type": "object",
+		"properties": {
+			"actionStatus": {"const": "CompletedActionStatus"},
+			"fromLocation": {"type": "string"},
+			"toLocation": {"type": "string", "minLength": 1},
+			"offer": {"type": "string", "minLength": 1}
+		},
+		"required": ["actionStatus", "toLocation", "offer"]
+	}`,
+}
+
+var schemas = map[string]*gojsonschema.Schema{}
+
+func init() {
+	for k, s := range EventSchemas {
+		schema, err := gojsonschema.NewSchema(gojsonschema.NewStringLoader(s))
+		if err != nil {
+			fatal("Error compiling schema", "schema", k, "err", err)
+		}
+		schemas[k] = schema
+	}
+}
+
+// ValidateEvent checks the data of an inbound participant event against its
+// schema and returns the problems found. Events without a schema are valid.
+func ValidateEvent(event *CloudEvent) []string {
+	role := strings.Split(event.Source, ".")[0]
+	schema, ok := schemas[role+" "+event.Type]
+	if !ok {
+		return nil
+	}
+
+	if len(event.Data) == 0 {
+		return []string{"(root): data is required"}
+	}
+
+	result, err := schema.Validate(gojsonschema.NewBytesLoader(event.Data))
+	if err != nil {
+		return []string{"(root): " + err.Error()}
+	}
+
+	var errs []string
+	for _, e := range result.Errors() {
+		errs = append(errs, e.String())
+	}
+	return errs
+}
+
+// PublishRejected tells the source of an event that the controller ignored
+// it and why.
+func (airport *Airport) PublishRejected(event *CloudEvent, errs []string) {
+	body, _ := json.Marshal(struct {
+		ID     string   `json:"id"`
+		Type   string   `json:"type"`
+		Errors []string `json:"errors"`
+	}{event.ID, event.Type, errs})
+
+	airport.Log().Warn("Rejected event", "errors", errs)
+	airport.Publish(&CloudEvent{
+		Type:    "Controller.Rejected",
+		Source:  "Controller",
+		Subject: event.Source,
+		Cause:   event.ID,
+		Data:    body,
+	})
+}
@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestValidateEventOptionalFields(t *testing.T) {
+	for _, event := range []CloudEvent{
+		{Type: "Connection", Source: "Retailer.r1", Data: []byte(`{}`)},
+		{Type: "TransferAction.ActionStatus.ActiveActionStatus", Source: "Carrier.c1",
+			Data: []byte(`{"actionStatus":"ActiveActionStatus","fromLocation":"Supplier.s1","toLocation":"Retailer.r1"}`)},
+	} {
+		if errs := ValidateEvent(&event); len(errs) > 0 {
+			t.Errorf("%s from %s rejected: %v", event.Type, event.Source, errs)
+		}
+	}
+
+	missing := CloudEvent{Type: "TransferAction.ActionStatus.ActiveActionStatus", Source: "Carrier.c1",
+		Data: []byte(`{"actionStatus":"ActiveActionStatus","fromLocation":"Supplier.s1"}`)}
+	if errs := ValidateEvent(&missing); len(errs) == 0 {
+		t.Error("ActiveActionStatus without a toLocation accepted")
+	}
+}
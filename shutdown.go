@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// shutdownTimeout bounds how long the HTTP requests in flight and the
+// events already received get to finish.
+const shutdownTimeout = 10 * time.Second
+
+// closeGrace is how long the websockets get to answer the close frame.
+const closeGrace = time.Second
+
+// sockets are the open customer and view websockets.
+var sockets = map[*websocket.Conn]bool{}
+var sockets_mu = &sync.Mutex{}
+
+// TrackSocket adds c to the websockets closed on shutdown until the
+// returned func is called.
+func TrackSocket(c *websocket.Conn) func() {
+	sockets_mu.Lock()
+	sockets[c] = true
+	sockets_mu.Unlock()
+
+	return func() {
+		sockets_mu.Lock()
+		delete(sockets, c)
+		sockets_mu.Unlock()
+	}
+}
+
+// CloseSockets sends every websocket a close frame and, once they've had a
+// moment to answer it, closes them.
+func CloseSockets() {
+	sockets_mu.Lock()
+	conns := []*websocket.Conn{}
+	for c := range sockets {
+		conns = append(conns, c)
+	}
+	sockets_mu.Unlock()
+
+	if len(conns) == 0 {
+		return
+	}
+
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "controller shutting down")
+	deadline := time.Now().Add(closeGrace)
+	for _, c := range conns {
+		c.WriteControl(websocket.CloseMessage, msg, deadline)
+	}
+	time.Sleep(time.Until(deadline))
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+// PublishOffline tells the participants the controller is going away. The
+// caller must hold the airport lock.
+func (airport *Airport) PublishOffline() {
+	airport.Log().Info("Publishing offline")
+	airport.Publish(&CloudEvent{
+		Type:   "Controller.Offline",
+		Source: "Controller",
+	})
+}
+
+// Shutdown stops the controller once the airports' listeners, whose
+// context has been cancelled, have returned: it stops serving HTTP and the
+// websockets, then stops every airport.
+func Shutdown(server *http.Server, offline bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	slog.Info("Shutting down")
+	server.SetKeepAlivesEnabled(false)
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("Error stopping HTTP server", "err", err)
+	}
+	CloseSockets()
+
+	for _, name := range AirportNames() {
+		airports[name].Stop(ctx, offline)
+	}
+
+	if err := journal.Close(); err != nil {
+		slog.Error("Error closing journal", "err", err)
+	}
+	slog.Info("Stopped")
+}
+
+// Stop lets the listener handle the events already received, stops the
+// timeouts and closes the transport. What's pending is kept in the final
+// snapshot, if there's a state file, for the next controller to pick up.
+func (airport *Airport) Stop(ctx context.Context, offline bool) {
+	select {
+	case <-airport.listening:
+	case <-ctx.Done():
+		slog.Warn("Gave up waiting for the transport listener", "airport", airport.Name)
+	}
+
+	airport.Mutex.Lock()
+	airport.stopping = true
+	for _, ate := range airport.ates {
+		ate.Timer.Stop()
+	}
+	if offline {
+		airport.PublishOffline()
+	}
+	airport.Mutex.Unlock()
+
+	if airport.StatePath != "" {
+		if err := airport.SaveSnapshot(airport.StatePath); err != nil {
+			slog.Error("Error saving snapshot", "airport", airport.Name, "path", airport.StatePath, "err", err)
+		}
+	}
+
+	if err := airport.Transport.Close(); err != nil {
+		slog.Error("Error closing transport", "airport", airport.Name, "err", err)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// Simulated participants run inside the controller on an airport's
+// ChannelTransport, so the airport can be tried out, and tested, without a
+// broker or any team's code. They answer the controller's events the way a
+// team's participants are expected to: the retailers serve the passengers
+// and order from the suppliers when they run low, the suppliers hand the
+// orders to the carriers, and the carriers deliver them.
+
+const (
+	simStock    = 5 // what a simulated retailer starts with, and a delivery brings, of every product
+	simLowWater = 2 // a simulated retailer orders a product once it has fewer than this
+)
+
+// SimParticipant is a simulated participant. Its handler is called with every
+// event on the transport but its own, one at a time, so it needs no locking.
+type SimParticipant struct {
+	Name      string // its source, e.g. "Retailer.Sim1"
+	transport *ChannelTransport
+	connected bool
+	connect   func()
+	handle    func(event *CloudEvent)
+}
+
+// Send publishes an event from the participant on the transport.
+func (p *SimParticipant) Send(eventType string, subject string, cause string, data interface{}) {
+	body, _ := json.Marshal(data)
+	p.transport.Send(&CloudEvent{
+		Type:    eventType,
+		Source:  p.Name,
+		Subject: subject,
+		Cause:   cause,
+		Data:    body,
+	})
+}
+
+// Run subscribes the participant to the transport and has it handle the
+// events until ctx is done. It connects when the controller resets the
+// airport or comes up.
+func (p *SimParticipant) Run(ctx context.Context) {
+	events := p.transport.Subscribe(ctx)
+	go func() {
+		for {
+			select {
+			case event := <-events:
+				if event.Source == p.Name {
+					continue
+				}
+				switch {
+				case event.Source == "Controller" && event.Type == "Reset":
+					p.connected = false
+					fallthrough
+				case event.Source == "Controller" && event.Type == "Controller.Catalog" && event.Subject == "" && !p.connected:
+					p.connected = true
+					p.connect()
+				default:
+					p.handle(event)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// NewSimRetailer returns a retailer selling every product in the catalog.
+func NewSimRetailer(name string, catalog Catalog, t *ChannelTransport) *SimParticipant {
+	p := &SimParticipant{Name: "Retailer." + name, transport: t}
+	stock := map[string]int{}
+	ordered := map[string]bool{}
+
+	var menu []string
+	for _, product := range catalog {
+		menu = append(menu, product.ID)
+	}
+
+	// The cause is the event the new inventory level answers, if any
+	inventory := func(offer string, cause string) {
+		p.Send("Offer.InventoryLevel", "", cause, map[string]interface{}{
+			"offer":          offer,
+			"inventoryLevel": stock[offer],
+		})
+		if stock[offer] < simLowWater && !ordered[offer] {
+			ordered[offer] = true
+			p.Send("Order.OrderStatus.OrderReleased", "", "", map[string]string{
+				"orderStatus": "OrderReleased",
+				"offer":       offer,
+			})
+		}
+	}
+
+	p.connect = func() {
+		p.Send("Connection", "", "", map[string]interface{}{"organization": name, "menu": menu})
+		for _, offer := range menu {
+			stock[offer], ordered[offer] = simStock, false
+			inventory(offer, "")
+		}
+	}
+
+	p.handle = func(event *CloudEvent) {
+		var data struct {
+			Provider     string `json:"provider"`
+			OrderStatus  string `json:"orderStatus"`
+			ActionStatus string `json:"actionStatus"`
+			ToLocation   string `json:"toLocation"`
+			Offer        string `json:"offer"`
+		}
+		if json.Unmarshal(event.Data, &data) != nil {
+			return
+		}
+		offer := catalog.OfferID(data.Offer)
+
+		switch {
+		case strings.HasPrefix(event.Source, "Passenger") && data.OrderStatus == "OrderReleased" && data.Provider == p.Name:
+			if stock[offer] == 0 {
+				return
+			}
+			stock[offer]--
+			p.Send("Order.OrderStatus.OrderDelivered", event.Subject, event.ID, map[string]string{
+				"orderStatus": "OrderDelivered",
+				"offer":       offer,
+			})
+			inventory(offer, "")
+		case strings.HasPrefix(event.Source, "Carrier.") && data.ActionStatus == "CompletedActionStatus" && data.ToLocation == p.Name:
+			stock[offer] += simStock
+			ordered[offer] = false
+			inventory(offer, event.ID)
+		}
+	}
+
+	return p
+}
+
+// NewSimSupplier returns a supplier that hands every order it's given a job
+// for to the carriers.
+func NewSimSupplier(name string, t *ChannelTransport) *SimParticipant {
+	p := &SimParticipant{Name: "Supplier." + name, transport: t}
+	var jobs []SupplierJob
+
+	p.connect = func() {
+		jobs = nil
+		p.Send("Connection", "", "", map[string]interface{}{})
+	}
+
+	p.handle = func(event *CloudEvent) {
+		if event.Type == "Offer.Product" && event.Subject == p.Name {
+			jobs = nil
+			json.Unmarshal(event.Data, &jobs)
+			return
+		}
+		if event.Type != "Order.OrderStatus.OrderReleased" {
+			return
+		}
+
+		var data struct {
+			Customer string `json:"customer"`
+			Offer    string `json:"offer"`
+		}
+		if json.Unmarshal(event.Data, &data) != nil {
+			return
+		}
+
+		// Retailers order from whoever has the job, the controller restocks
+		// from a supplier in particular and re-routes orders to one
+		target, _ := event.Extensions["target"].(string)
+		origin, _ := event.Extensions["origin"].(string)
+		retailer := event.Source
+		switch {
+		case event.Source == "Controller" && target == p.Name && strings.HasPrefix(origin, "Retailer."):
+			retailer = origin
+		case event.Source == "Controller" && (event.Subject == p.Name || target == p.Name):
+			retailer = data.Customer
+		case !strings.HasPrefix(event.Source, "Retailer."):
+			return
+		}
+
+		for _, j := range jobs {
+			for _, o := range j.Offers {
+				if j.Retailer == retailer && strings.EqualFold(o, data.Offer) {
+					p.Send("TransferAction.ActionStatus.PotentialActionStatus", "", event.ID, map[string]string{
+						"actionStatus": "PotentialActionStatus",
+						"fromLocation": p.Name,
+						"toLocation":   retailer,
+						"offer":        data.Offer,
+					})
+					return
+				}
+			}
+		}
+	}
+
+	return p
+}
+
+// NewSimCarrier returns a carrier that picks up and delivers everything it's
+// got a job for.
+func NewSimCarrier(name string, t *ChannelTransport) *SimParticipant {
+	p := &SimParticipant{Name: "Carrier." + name, transport: t}
+	var jobs []CarrierJob
+
+	p.connect = func() {
+		jobs = nil
+		p.Send("Connection", "", "", map[string]interface{}{})
+	}
+
+	p.handle = func(event *CloudEvent) {
+		if event.Type == "Offer.Service.Transport" && event.Subject == p.Name {
+			jobs = nil
+			json.Unmarshal(event.Data, &jobs)
+			return
+		}
+
+		var data struct {
+			ActionStatus string `json:"actionStatus"`
+			FromLocation string `json:"fromLocation"`
+			ToLocation   string `json:"toLocation"`
+			Offer        string `json:"offer"`
+		}
+		if json.Unmarshal(event.Data, &data) != nil {
+			return
+		}
+
+		// A pickup is re-routed by the controller when its carrier goes away
+		target, _ := event.Extensions["target"].(string)
+		switch {
+		case (strings.HasPrefix(event.Source, "Supplier.") || target == p.Name) && data.ActionStatus == "PotentialActionStatus":
+			for _, j := range jobs {
+				if j.Supplier == data.FromLocation && j.Retailer == data.ToLocation {
+					data.ActionStatus = "ActiveActionStatus"
+					p.Send("TransferAction.ActionStatus.ActiveActionStatus", p.Name, event.ID, data)
+					return
+				}
+			}
+		case event.Source == "Controller" && data.ActionStatus == "ArrivedActionStatus" && event.Subject == p.Name:
+			data.ActionStatus = "CompletedActionStatus"
+			p.Send("TransferAction.ActionStatus.CompletedActionStatus", "", event.ID, data)
+		}
+	}
+
+	return p
+}
+
+// Simulate runs n simulated retailers, suppliers and carriers in the
+// airport until ctx is done. It must be called before the airport starts
+// so they see it come up.
+func (airport *Airport) Simulate(ctx context.Context, n int) {
+	t := airport.Transport.(*ChannelTransport)
+	for i := 1; i <= n; i++ {
+		name := "Sim" + strconv.Itoa(i)
+		NewSimRetailer(name, airport.Catalog, t).Run(ctx)
+		NewSimSupplier(name, t).Run(ctx)
+		NewSimCarrier(name, t).Run(ctx)
+	}
+}
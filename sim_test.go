@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSimulatedOrderRoundTrip(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	transport := NewChannelTransport()
+	airport := NewAirport("simtest", transport, RoundRobin{})
+	defer delete(airports, airport.Name)
+	airport.Catalog = DefaultCatalog
+	airport.Simulate(ctx, 1)
+	events := transport.Subscribe(ctx)
+	airport.Start(ctx, time.Second)
+
+	// The retailer connects once the controller comes up
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		airport.Mutex.RLock()
+		r := airport.GetRetailer("Retailer.Sim1")
+		stocked := r != nil && r.InStock("small")
+		airport.Mutex.RUnlock()
+		if stocked {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("simulated retailer never connected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	order := &CloudEvent{
+		Type:    "Order.OrderStatus.OrderReleased",
+		Source:  "Passenger",
+		Subject: "Customer.test",
+		Data:    []byte(`{"provider":"Retailer.Sim1","orderStatus":"OrderReleased","customer":"Customer.test","offer":"small"}`),
+	}
+	airport.Mutex.Lock()
+	airport.Publish(order)
+	airport.Mutex.Unlock()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.Type == "Order.OrderStatus.OrderDelivered" && event.Cause == order.ID {
+				if event.Source != "Retailer.Sim1" || event.Subject != order.Subject {
+					t.Fatalf("delivered by %s to %s, want Retailer.Sim1 to %s", event.Source, event.Subject, order.Subject)
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatal("order never delivered")
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// latencySamples is how many of each participant's latest response times
+// are kept for the percentiles.
+const latencySamples = 100
+
+// ParticipantStats measures how well a participant answers the requests the
+// timeout rules watch: how fast, and how often it doesn't.
+type ParticipantStats struct {
+	Name        string  `json:"name"`
+	Role        string  `json:"role"`
+	Responses   int     `json:"responses"`
+	Late        int     `json:"late"`        // responses after the deadline
+	Timeouts    int     `json:"timeouts"`    // requests it missed the deadline for, answered late or not
+	Disconnects int     `json:"disconnects"` // times it was disconnected for it
+	OnTime      float64 `json:"onTime"`      // share of requests answered before the deadline
+	MeanMs      int64   `json:"meanMs"`
+	MedianMs    int64   `json:"medianMs"`
+	P95Ms       int64   `json:"p95Ms"`
+	MinMs       int64   `json:"minMs"`
+	MaxMs       int64   `json:"maxMs"`
+
+	total   time.Duration
+	samples []time.Duration
+}
+
+func (airport *Airport) statsFor(name string) *ParticipantStats {
+	s, ok := airport.stats[name]
+	if !ok {
+		s = &ParticipantStats{Name: name, Role: strings.Split(name, ".")[0]}
+		airport.stats[name] = s
+	}
+	return s
+}
+
+// AnsweredTimeout returns the timeout the event answers: the one watching the
+// event named by its cause or, for events without one, a timeout waiting on
+// the event's source about the same subject whose rule lists the event's
+// type in AnswerTypes. The caller must hold the airport lock.
+func (airport *Airport) AnsweredTimeout(event *CloudEvent) *ActiveTimeoutEvent {
+	if event.Cause != "" {
+		return airport.ates[event.Cause]
+	}
+	if event.Subject == "" {
+		return nil
+	}
+	for _, ate := range airport.ates {
+		if ate.Target != event.Source || ate.Event.Subject != event.Subject {
+			continue
+		}
+		for _, t := range ate.Rule.AnswerTypes {
+			if t == event.Type {
+				return ate
+			}
+		}
+	}
+	return nil
+}
+
+// RecordResponse counts the event as the answer to ate of the participant
+// it was waiting on. Anyone else's answer, such as the one of a participant
+// that took over the jobs, isn't counted.
+func (airport *Airport) RecordResponse(ate *ActiveTimeoutEvent, event *CloudEvent) {
+	if event.Source != ate.Target {
+		return
+	}
+
+	s := airport.statsFor(event.Source)
+	d := time.Since(ate.Started)
+	s.Responses++
+	if ate.Attempt > 0 || ate.Warned {
+		s.Late++
+	}
+	s.total += d
+	s.samples = append(s.samples, d)
+	if len(s.samples) > latencySamples {
+		s.samples = s.samples[1:]
+	}
+	if s.Responses == 1 || d < time.Duration(s.MinMs)*time.Millisecond {
+		s.MinMs = d.Milliseconds()
+	}
+	if d > time.Duration(s.MaxMs)*time.Millisecond {
+		s.MaxMs = d.Milliseconds()
+	}
+}
+
+// RecordTimeout counts a missed deadline against the participant.
+func (airport *Airport) RecordTimeout(name string) {
+	airport.statsFor(name).Timeouts++
+}
+
+// RecordDisconnect counts a disconnect for missing a deadline against the
+// participant.
+func (airport *Airport) RecordDisconnect(name string) {
+	airport.statsFor(name).Disconnects++
+}
+
+// Leaderboard returns everyone's stats, best first: most often on time, then
+// fastest. The caller must hold the airport lock.
+func (airport *Airport) Leaderboard() []ParticipantStats {
+	list := []ParticipantStats{}
+	for _, s := range airport.stats {
+		entry := *s
+		entry.samples = nil
+		if n := s.Responses + s.Timeouts - s.Late; n > 0 {
+			entry.OnTime = float64(s.Responses-s.Late) / float64(n)
+		}
+		if s.Responses > 0 {
+			entry.MeanMs = (s.total / time.Duration(s.Responses)).Milliseconds()
+		}
+		if len(s.samples) > 0 {
+			sorted := append([]time.Duration{}, s.samples...)
+			sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+			entry.MedianMs = sorted[len(sorted)/2].Milliseconds()
+			entry.P95Ms = sorted[(len(sorted)*95)/100].Milliseconds()
+		}
+		list = append(list, entry)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].OnTime != list[j].OnTime {
+			return list[i].OnTime > list[j].OnTime
+		}
+		if (list[i].Responses == 0) != (list[j].Responses == 0) {
+			return list[i].Responses > 0
+		}
+		if list[i].MedianMs != list[j].MedianMs {
+			return list[i].MedianMs < list[j].MedianMs
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// GET /stats returns the leaderboard, optionally only for one ?role=.
+func (airport *Airport) HandleStats(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+
+	airport.Mutex.RLock()
+	list := airport.Leaderboard()
+	airport.Mutex.RUnlock()
+
+	if role := r.URL.Query().Get("role"); role != "" {
+		filtered := []ParticipantStats{}
+		for _, s := range list {
+			if s.Role == role {
+				filtered = append(filtered, s)
+			}
+		}
+		list = filtered
+	}
+	writeJSON(w, list)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAnsweredTimeoutWithoutCause(t *testing.T) {
+	airport := NewAirport("slatest", NewChannelTransport(), RoundRobin{})
+	defer delete(airports, airport.Name)
+
+	order := &CloudEvent{ID: "order", Type: "Order.OrderStatus.OrderReleased", Source: "Passenger", Subject: "Customer.1"}
+	rule := TimeoutEvent{Name: "passenger-order", AnswerTypes: []string{"Order.OrderStatus.OrderDelivered"}}
+	airport.Mutex.Lock()
+	defer airport.Mutex.Unlock()
+	ate := airport.StartTimeout(order, rule, "Retailer.r1", time.Hour)
+	defer ate.Timer.Stop()
+
+	unrelated := &CloudEvent{Type: "Offer.InventoryLevel", Source: "Retailer.r1", Subject: "Customer.1"}
+	if got := airport.AnsweredTimeout(unrelated); got != nil {
+		t.Errorf("%s answered the timeout on %s", unrelated.Type, got.Event.ID)
+	}
+
+	other := &CloudEvent{Type: "Order.OrderStatus.OrderDelivered", Source: "Retailer.r2", Subject: "Customer.1"}
+	if got := airport.AnsweredTimeout(other); got != nil {
+		t.Errorf("a participant not waited on answered the timeout on %s", got.Event.ID)
+	}
+
+	delivered := &CloudEvent{Type: "Order.OrderStatus.OrderDelivered", Source: "Retailer.r1", Subject: "Customer.1"}
+	if got := airport.AnsweredTimeout(delivered); got != ate {
+		t.Errorf("%s didn't answer the timeout", delivered.Type)
+	}
+
+	// Without answer types only the cause answers
+	ate.Rule.AnswerTypes = nil
+	if got := airport.AnsweredTimeout(delivered); got != nil {
+		t.Errorf("%s answered a timeout whose rule has no answer types", delivered.Type)
+	}
+	delivered.Cause = order.ID
+	if got := airport.AnsweredTimeout(delivered); got != ate {
+		t.Errorf("%s with the order as its cause didn't answer the timeout", delivered.Type)
+	}
+}
+
+func TestRejectedEventDoesNotAnswerTimeout(t *testing.T) {
+	airport := NewAirport("slatest", NewChannelTransport(), RoundRobin{})
+	defer delete(airports, airport.Name)
+
+	order := &CloudEvent{ID: "order", Type: "Order.OrderStatus.OrderReleased", Source: "Passenger", Subject: "Customer.1"}
+	airport.Mutex.Lock()
+	ate := airport.StartTimeout(order, TimeoutEvent{Name: "passenger-order"}, "Retailer.r1", time.Hour)
+	airport.Mutex.Unlock()
+	defer ate.Timer.Stop()
+
+	airport.ProcessEvent(CloudEvent{
+		ID:      "bad",
+		Type:    "Order.OrderStatus.OrderDelivered",
+		Source:  "Retailer.r1",
+		Subject: "Customer.1",
+		Cause:   order.ID,
+		Data:    []byte(`{"orderStatus":"Lost"}`),
+	})
+
+	airport.Mutex.RLock()
+	defer airport.Mutex.RUnlock()
+	if airport.ates[order.ID] != ate {
+		t.Error("a rejected event answered the timeout")
+	}
+	if s := airport.stats["Retailer.r1"]; s != nil && s.Responses > 0 {
+		t.Error("a rejected event counted as a response")
+	}
+}
+
+func TestRecordResponseOnlyCountsTarget(t *testing.T) {
+	airport := NewAirport("slatest", NewChannelTransport(), RoundRobin{})
+	defer delete(airports, airport.Name)
+
+	order := &CloudEvent{ID: "order", Type: "Order.OrderStatus.OrderReleased", Source: "Passenger", Subject: "Customer.1"}
+	airport.Mutex.Lock()
+	defer airport.Mutex.Unlock()
+	ate := airport.StartTimeout(order, TimeoutEvent{Name: "passenger-order"}, "Retailer.r1", time.Hour)
+	defer ate.Timer.Stop()
+
+	airport.RecordResponse(ate, &CloudEvent{Source: "Retailer.r2", Cause: order.ID})
+	if s := airport.stats["Retailer.r2"]; s != nil && s.Responses > 0 {
+		t.Error("a participant not waited on was counted as answering")
+	}
+
+	airport.RecordResponse(ate, &CloudEvent{Source: "Retailer.r1", Cause: order.ID})
+	if s := airport.stats["Retailer.r1"]; s == nil || s.Responses != 1 {
+		t.Error("the participant waited on wasn't counted as answering")
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Snapshot is the on-disk form of the airport, written periodically so a
+// restarted controller can pick up where the previous one left off instead
+// of resetting every participant.
+type Snapshot struct {
+	Time      time.Time            `json:"time"`
+	Disabled  bool                 `json:"disabled"`
+	Retailers []RetailerSnapshot   `json:"retailers"`
+	Suppliers []*Supplier          `json:"suppliers"`
+	Carriers  []*Carrier           `json:"carriers"`
+	Timeouts  []TimeoutSnapshot    `json:"timeouts"`
+	Cooldowns map[string]*Cooldown `json:"cooldowns,omitempty"`
+	Orders    []OrderSnapshot      `json:"orders,omitempty"`
+	Finished  []OrderSnapshot      `json:"finished,omitempty"`
+	Stats     []StatsSnapshot      `json:"stats,omitempty"`
+}
+
+type RetailerSnapshot struct {
+	Name      string             `json:"name"`
+	Nickname  string             `json:"nickname"`
+	Logo      string             `json:"logo"`
+	Offers    map[string]int     `json:"offers"`
+	Menu      []string           `json:"menu"`
+	Customers []CustomerSnapshot `json:"customers"`
+}
+
+// OrderSnapshot is an order with what /orders leaves out of it.
+type OrderSnapshot struct {
+	Order
+	Request *CloudEvent `json:"request,omitempty"`
+	Pickup  *CloudEvent `json:"pickup,omitempty"`
+	Events  []string    `json:"events,omitempty"`
+}
+
+func (o *Order) snapshot() OrderSnapshot {
+	saved := OrderSnapshot{Order: *o, Request: o.Request, Pickup: o.Pickup}
+	for id := range o.ids {
+		saved.Events = append(saved.Events, id)
+	}
+	return saved
+}
+
+func (airport *Airport) restoreOrder(saved OrderSnapshot) *Order {
+	o := saved.Order
+	o.Request, o.Pickup = saved.Request, saved.Pickup
+	o.ids = map[string]bool{}
+	for _, id := range saved.Events {
+		o.ids[id] = true
+	}
+	o.airport = airport
+	return &o
+}
+
+// StatsSnapshot is a participant's stats with the response times the
+// leaderboard works out its figures from.
+type StatsSnapshot struct {
+	ParticipantStats
+	Total   Duration   `json:"total"`
+	Samples []Duration `json:"samples,omitempty"`
+}
+
+type CustomerSnapshot struct {
+	Id    string `json:"id"`
+	State int    `json:"state"`
+}
+
+type TimeoutSnapshot struct {
+	Event    *CloudEvent  `json:"event"`
+	Rule     TimeoutEvent `json:"rule"`
+	Target   string       `json:"target"`
+	Deadline time.Time    `json:"deadline"`
+	Started  time.Time    `json:"started"`
+	Attempt  int          `json:"attempt"`
+	Warned   bool         `json:"warned"`
+}
+
+// TakeSnapshot captures the airport. The caller must hold the airport lock.
+func (airport *Airport) TakeSnapshot() *Snapshot {
+	snapshot := &Snapshot{
+		Time:      time.Now(),
+		Disabled:  airport.Disabled,
+		Suppliers: airport.Suppliers,
+		Carriers:  airport.Carriers,
+		Cooldowns: airport.cooldowns,
+	}
+
+	for _, r := range airport.Retailers {
+		rs := RetailerSnapshot{
+			Name:     r.Name,
+			Nickname: r.Nickname,
+			Logo:     r.Logo,
+			Offers:   r.Offers,
+			Menu:     r.Menu,
+		}
+		for _, c := range r.Customers {
+			rs.Customers = append(rs.Customers, CustomerSnapshot{Id: c.Id, State: c.State})
+		}
+		snapshot.Retailers = append(snapshot.Retailers, rs)
+	}
+
+	for _, o := range airport.orders {
+		snapshot.Orders = append(snapshot.Orders, o.snapshot())
+	}
+	for _, o := range airport.finished {
+		snapshot.Finished = append(snapshot.Finished, o.snapshot())
+	}
+
+	for _, s := range airport.stats {
+		saved := StatsSnapshot{ParticipantStats: *s, Total: Duration(s.total)}
+		saved.samples = nil
+		for _, d := range s.samples {
+			saved.Samples = append(saved.Samples, Duration(d))
+		}
+		snapshot.Stats = append(snapshot.Stats, saved)
+	}
+
+	for _, ate := range airport.ates {
+		snapshot.Timeouts = append(snapshot.Timeouts, TimeoutSnapshot{
+			Event:    ate.Event,
+			Rule:     ate.Rule,
+			Target:   ate.Target,
+			Deadline: ate.Deadline,
+			Started:  ate.Started,
+			Attempt:  ate.Attempt,
+			Warned:   ate.Warned,
+		})
+	}
+
+	return snapshot
+}
+
+// snapshot_mu keeps the periodic and the final snapshot from being written
+// at the same time.
+var snapshot_mu = &sync.Mutex{}
+
+// SaveSnapshot writes the airport to path, replacing the previous snapshot
+// atomically.
+func (airport *Airport) SaveSnapshot(path string) error {
+	snapshot_mu.Lock()
+	defer snapshot_mu.Unlock()
+
+	airport.Mutex.RLock()
+	body, err := json.Marshal(airport.TakeSnapshot())
+	airport.Mutex.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, body, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadSnapshot restores the airport from path. A missing file is not an
+// error, there's just nothing to restore.
+func (airport *Airport) LoadSnapshot(path string) error {
+	body, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(body, &snapshot); err != nil {
+		return err
+	}
+
+	airport.Mutex.Lock()
+	defer airport.Mutex.Unlock()
+
+	airport.Disabled = snapshot.Disabled
+	airport.Suppliers = snapshot.Suppliers
+	airport.Carriers = snapshot.Carriers
+	for _, s := range airport.Suppliers {
+		s.airport = airport
+	}
+	for _, c := range airport.Carriers {
+		c.airport = airport
+	}
+	airport.Retailers = nil
+	for _, rs := range snapshot.Retailers {
+		r := &Retailer{
+			Name:     rs.Name,
+			Nickname: rs.Nickname,
+			Logo:     rs.Logo,
+			Offers:   rs.Offers,
+			Menu:     rs.Menu,
+			airport:  airport,
+		}
+		if r.Offers == nil {
+			r.Offers = map[string]int{}
+		}
+		// Older snapshots have no menus
+		if r.Menu == nil {
+			r.SetMenu(nil)
+		}
+		for _, cs := range rs.Customers {
+			r.Customers = append(r.Customers, &Customer{Retailer: r, Id: cs.Id, State: cs.State})
+		}
+		airport.Retailers = append(airport.Retailers, r)
+	}
+
+	// The goroutines that moved customers along were lost with the previous
+	// process, start them again.
+	for _, r := range airport.Retailers {
+		for i, c := range r.Customers {
+			switch c.State {
+			case CUSTOMER_WALKING, CUSTOMER_INLINE:
+				c.State = CUSTOMER_INLINE
+				if i == 0 {
+					c.Order()
+				}
+			case CUSTOMER_ORDERING:
+				c.Order()
+			case CUSTOMER_ORDERED:
+				c.AwaitDelivery()
+			}
+		}
+	}
+
+	// Older snapshots have no cooldowns, expired ones are dropped with the
+	// next assignment
+	airport.cooldowns = map[string]*Cooldown{}
+	for name, cd := range snapshot.Cooldowns {
+		airport.cooldowns[name] = cd
+	}
+
+	airport.orders, airport.finished = nil, nil
+	for _, saved := range snapshot.Orders {
+		airport.orders = append(airport.orders, airport.restoreOrder(saved))
+	}
+	for _, saved := range snapshot.Finished {
+		airport.finished = append(airport.finished, airport.restoreOrder(saved))
+	}
+
+	airport.stats = map[string]*ParticipantStats{}
+	for _, saved := range snapshot.Stats {
+		s := saved.ParticipantStats
+		s.total = time.Duration(saved.Total)
+		for _, d := range saved.Samples {
+			s.samples = append(s.samples, time.Duration(d))
+		}
+		airport.stats[s.Name] = &s
+	}
+
+	airport.restoredTimeouts = snapshot.Timeouts
+	airport.restored = true
+
+	airport.Log().Info("Restored snapshot", "path", path, "retailers", len(airport.Retailers),
+		"suppliers", len(airport.Suppliers), "carriers", len(airport.Carriers), "timeouts", len(snapshot.Timeouts), "cooldowns", len(airport.cooldowns), "orders", len(airport.orders))
+	return nil
+}
+
+// SaveSnapshots writes a snapshot to path every interval until ctx is done.
+func (airport *Airport) SaveSnapshots(ctx context.Context, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if err := airport.SaveSnapshot(path); err != nil {
+			slog.Error("Error saving snapshot", "airport", airport.Name, "path", path, "err", err)
+		}
+	}
+}
+
+// OnConnect runs every time the transport (re)connects. A controller that
+// restored its state republishes the participants' jobs and rearms the
+// pending timeouts with what was left of them; otherwise every participant
+// is told to reset. Either way they're sent the catalog. Restored
+// participants banned while the controller was down are disconnected.
+func (airport *Airport) OnConnect() {
+	airport.Mutex.Lock()
+	if !airport.restored {
+		airport.PublishReset()
+		airport.PublishCatalog("")
+		airport.Mutex.Unlock()
+		return
+	}
+	airport.restored = false
+	airport.PublishCatalog("")
+
+	for _, s := range airport.Suppliers {
+		s.UpdateJob()
+	}
+	for _, c := range airport.Carriers {
+		c.UpdateJob()
+	}
+
+	for _, t := range airport.restoredTimeouts {
+		d := time.Until(t.Deadline)
+		if d < 0 {
+			d = 0
+		}
+		ate := airport.StartTimeout(t.Event, t.Rule, t.Target, d)
+		ate.Attempt, ate.Warned = t.Attempt, t.Warned
+		if !t.Started.IsZero() {
+			ate.Started = t.Started
+		}
+	}
+	airport.restoredTimeouts = nil
+	airport.disconnectBanned()
+	airport.Mutex.Unlock()
+}
@@ -9,6 +9,7 @@ import (
 	"math/rand"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -76,14 +77,16 @@ allofit:
 					continue allofit
 				}
 
-				switch string(msg) {
-				case "o":
+				switch {
+				case len(msg) > 1 && msg[0] == 'o':
+					// The retailer's menu, the IDs of what's in stock
+					menu := strings.Split(string(msg[1:]), ",")
 					time.Sleep(time.Duration(rand.Intn(1500)) * time.Millisecond)
-					if err := c.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("o%d", rand.Intn(3)))); err != nil {
+					if err := c.WriteMessage(websocket.TextMessage, []byte("o"+menu[rand.Intn(len(menu))])); err != nil {
 						fmt.Fprintf(os.Stderr, "Failed to send message: %v\n", err)
 						continue allofit
 					}
-				case "c", "s", "f":
+				case string(msg) == "c", string(msg) == "s", string(msg) == "f", string(msg) == "n":
 					break loop
 				}
 			}
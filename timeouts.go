@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	EXPECT_PROVIDER = iota // Expect response from provider in data
+	EXPECT_SUPPLIER = iota // Expect response from supplier that hanldes retailer (from source)
+	EXPECT_RETAILER = iota // Expect response from retailer (from toLocation)
+	EXPECT_CARRIER  = iota // Expect response from carrier that handles retailer and supplier (from toLocation and fromLocation)
+	EXPECT_SOURCE   = iota // Expect response from the event's source
+	EXPECT_SUBJECT  = iota // Expect response from the participant named by the event's subject
+	EXPECT_FIELD    = iota // Expect response from the participant named in data at ExpectPath
+)
+
+// ExpectNames are the names the EXPECT_* constants go by in the timeouts
+// file, in the same order.
+var ExpectNames = []string{"provider", "supplier", "retailer", "carrier", "source", "subject", "field"}
+
+// DefaultTimeout is used by rules that don't set one.
+const DefaultTimeout = 25 * time.Second
+
+// TimeoutEvent is a watchdog rule: when an event matching it is seen, the
+// participant it expects to answer is disconnected unless it sends an event
+// with the matching event's ID as its cause within Timeout.
+//
+// An empty matcher matches anything. Data and DataRegex are keyed by paths
+// into the event's data, with nested objects separated by dots, e.g.
+// "customer.id".
+type TimeoutEvent struct {
+	Name        string                 `json:"name,omitempty"`
+	Timeout     time.Duration          `json:"timeout"`
+	Type        string                 `json:"type,omitempty"`
+	TypePrefix  string                 `json:"typePrefix,omitempty"`
+	TypeRegex   string                 `json:"typeRegex,omitempty"`
+	Source      string                 `json:"source,omitempty"` // role, the first part of the source
+	SourceRegex string                 `json:"sourceRegex,omitempty"`
+	Data        map[string]interface{} `json:"data,omitempty"`
+	DataRegex   map[string]string      `json:"dataRegex,omitempty"`
+	Expect      int                    `json:"expect"`
+	ExpectPath  string                 `json:"expectPath,omitempty"`
+	Resend      bool                   `json:"resend,omitempty"`      // publish the event again once the participant is disconnected
+	AnswerTypes []string               `json:"answerTypes,omitempty"` // types of the events without a cause that answer it, see AnsweredTimeout
+	Escalation  *Escalation            `json:"escalation,omitempty"`
+
+	typeRegex   *regexp.Regexp
+	sourceRegex *regexp.Regexp
+	dataRegex   map[string]*regexp.Regexp
+}
+
+// DefaultTimeoutEvents are the rules used when there's no timeouts file.
+var DefaultTimeoutEvents = []TimeoutEvent{
+	{
+		Name:    "passenger-order",
+		Type:    "Order.OrderStatus.OrderReleased",
+		Source:  "Passenger",
+		Timeout: DefaultTimeout,
+		Data: map[string]interface{}{
+			"orderStatus": "OrderReleased",
+		},
+		Expect:      EXPECT_PROVIDER,
+		Resend:      false,
+		AnswerTypes: []string{"Order.OrderStatus.OrderDelivered"},
+	},
+	{
+		Name:    "retailer-order",
+		Type:    "Order.OrderStatus.OrderReleased",
+		Source:  "Retailer",
+		Timeout: DefaultTimeout,
+		Data: map[string]interface{}{
+			"orderStatus": "OrderReleased",
+		},
+		Expect: EXPECT_SUPPLIER,
+		Resend: true,
+	},
+	{
+		Name:    "supplier-pickup",
+		Type:    "TransferAction.ActionStatus.PotentialActionStatus",
+		Source:  "Supplier",
+		Timeout: DefaultTimeout,
+		Data: map[string]interface{}{
+			"actionStatus": "PotentialActionStatus",
+		},
+		Expect: EXPECT_CARRIER,
+		Resend: true,
+	},
+	{
+		Name:    "controller-restock",
+		Type:    "Order.OrderStatus.OrderReleased",
+		Source:  "Controller",
+		Timeout: DefaultTimeout,
+		Data: map[string]interface{}{
+			"orderStatus": "OrderReleased",
+		},
+		Expect: EXPECT_SUBJECT,
+		Resend: false,
+	},
+	{
+		Name:    "carrier-arrived",
+		Type:    "TransferAction.ActionStatus.ArrivedActionStatus",
+		Source:  "Controller",
+		Timeout: DefaultTimeout,
+		Data: map[string]interface{}{
+			"actionStatus": "ArrivedActionStatus",
+		},
+		Expect: EXPECT_CARRIER,
+		Resend: true,
+	},
+	{
+		Name:    "carrier-delivered",
+		Type:    "TransferAction.ActionStatus.CompletedActionStatus",
+		Source:  "Carrier",
+		Timeout: DefaultTimeout,
+		Data: map[string]interface{}{
+			"actionStatus": "CompletedActionStatus",
+		},
+		Expect: EXPECT_RETAILER,
+		Resend: false,
+	},
+}
+
+// Timeouts returns the airport's rules in force. The slice is replaced
+// whenever they change, never changed in place, so it can be used after the
+// lock's let go.
+func (airport *Airport) Timeouts() []TimeoutEvent {
+	airport.rules_mu.RLock()
+	defer airport.rules_mu.RUnlock()
+	return airport.timeoutEvents
+}
+
+func (t TimeoutEvent) MarshalJSON() ([]byte, error) {
+	type alias TimeoutEvent
+	expect := ""
+	if t.Expect >= 0 && t.Expect < len(ExpectNames) {
+		expect = ExpectNames[t.Expect]
+	}
+	return json.Marshal(struct {
+		alias
+		Timeout string `json:"timeout"`
+		Expect  string `json:"expect"`
+	}{alias(t), t.Timeout.String(), expect})
+}
+
+// UnmarshalJSON reads a rule and compiles its regexes. Timeout is a duration
+// string like "25s" and Expect one of ExpectNames; the numbers older
+// snapshots hold are accepted too.
+func (t *TimeoutEvent) UnmarshalJSON(b []byte) error {
+	type alias TimeoutEvent
+	aux := struct {
+		*alias
+		Timeout json.RawMessage `json:"timeout"`
+		Expect  json.RawMessage `json:"expect"`
+	}{alias: (*alias)(t)}
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+
+	t.Timeout = DefaultTimeout
+	if len(aux.Timeout) > 0 {
+		var s string
+		if err := json.Unmarshal(aux.Timeout, &s); err == nil {
+			d, err := time.ParseDuration(s)
+			if err != nil || d <= 0 {
+				return errors.New("rule " + t.Name + ": timeout must be a positive duration, e.g. 25s")
+			}
+			t.Timeout = d
+		} else if err := json.Unmarshal(aux.Timeout, &t.Timeout); err != nil {
+			return errors.New("rule " + t.Name + ": bad timeout " + string(aux.Timeout))
+		}
+	}
+
+	t.Expect = -1
+	var s string
+	if err := json.Unmarshal(aux.Expect, &s); err == nil {
+		for i, name := range ExpectNames {
+			if s == name {
+				t.Expect = i
+			}
+		}
+	} else if json.Unmarshal(aux.Expect, &t.Expect) != nil {
+		t.Expect = -1
+	}
+	if t.Expect < 0 || t.Expect >= len(ExpectNames) {
+		return errors.New("rule " + t.Name + ": expect must be one of: " + strings.Join(ExpectNames, ", "))
+	}
+	if t.Expect == EXPECT_FIELD && t.ExpectPath == "" {
+		return errors.New("rule " + t.Name + ": expect field needs an expectPath")
+	}
+
+	return t.compile()
+}
+
+func (t *TimeoutEvent) compile() error {
+	var err error
+	t.typeRegex, t.sourceRegex, t.dataRegex = nil, nil, nil
+
+	if t.TypeRegex != "" {
+		if t.typeRegex, err = regexp.Compile(t.TypeRegex); err != nil {
+			return errors.New("rule " + t.Name + ": typeRegex: " + err.Error())
+		}
+	}
+	if t.SourceRegex != "" {
+		if t.sourceRegex, err = regexp.Compile(t.SourceRegex); err != nil {
+			return errors.New("rule " + t.Name + ": sourceRegex: " + err.Error())
+		}
+	}
+	for path, expr := range t.DataRegex {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return errors.New("rule " + t.Name + ": dataRegex " + path + ": " + err.Error())
+		}
+		if t.dataRegex == nil {
+			t.dataRegex = map[string]*regexp.Regexp{}
+		}
+		t.dataRegex[path] = re
+	}
+	return nil
+}
+
+// DataPath looks up a dotted path, e.g. "customer.id", in decoded JSON data.
+// Array elements are picked by index, e.g. "added.0.retailer".
+func DataPath(data map[string]interface{}, path string) (interface{}, bool) {
+	var v interface{} = data
+	for _, key := range strings.Split(path, ".") {
+		switch value := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = value[key]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(value) {
+				return nil, false
+			}
+			v = value[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// Matches reports whether the rule applies to the event, whose data has been
+// decoded into data.
+func (t *TimeoutEvent) Matches(event *CloudEvent, data map[string]interface{}) bool {
+	if t.Type != "" && t.Type != event.Type {
+		return false
+	}
+	if !strings.HasPrefix(event.Type, t.TypePrefix) {
+		return false
+	}
+	if t.typeRegex != nil && !t.typeRegex.MatchString(event.Type) {
+		return false
+	}
+	if t.Source != "" && t.Source != strings.Split(event.Source, ".")[0] {
+		return false
+	}
+	if t.sourceRegex != nil && !t.sourceRegex.MatchString(event.Source) {
+		return false
+	}
+
+	for path, want := range t.Data {
+		v, ok := DataPath(data, path)
+		if !ok || !reflect.DeepEqual(v, want) {
+			return false
+		}
+	}
+	for path, re := range t.dataRegex {
+		v, ok := DataPath(data, path)
+		if !ok || v == nil {
+			return false
+		}
+		s, ok := v.(string)
+		if !ok {
+			s = fmt.Sprint(v)
+		}
+		if !re.MatchString(s) {
+			return false
+		}
+	}
+	return true
+}
+
+// Target returns the name of the participant the rule expects to answer the
+// event in the airport, or "" if there's no one connected to wait on. The
+// caller must hold the airport lock.
+func (t *TimeoutEvent) Target(airport *Airport, event *CloudEvent, data map[string]interface{}) string {
+	switch t.Expect {
+	case EXPECT_PROVIDER:
+		name, _ := data["provider"].(string)
+		if r := airport.GetRetailer(name); r != nil {
+			return r.Name
+		}
+	case EXPECT_SUPPLIER:
+		if airport.GetRetailer(event.Source) == nil {
+			return ""
+		}
+
+		for _, s := range airport.Suppliers {
+			for _, j := range s.Jobs {
+				if j.Retailer == event.Source {
+					return s.Name
+				}
+			}
+		}
+	case EXPECT_RETAILER:
+		name, _ := data["toLocation"].(string)
+		if r := airport.GetRetailer(name); r != nil {
+			return r.Name
+		}
+	case EXPECT_CARRIER:
+		retailer, _ := data["toLocation"].(string)
+		if airport.GetRetailer(retailer) == nil {
+			return ""
+		}
+
+		supplier, _ := data["fromLocation"].(string)
+		if airport.GetSupplier(supplier) == nil {
+			return ""
+		}
+
+		for _, c := range airport.Carriers {
+			for _, j := range c.Jobs {
+				if j.Retailer == retailer && j.Supplier == supplier {
+					return c.Name
+				}
+			}
+		}
+	case EXPECT_SOURCE:
+		if airport.isParticipant(event.Source) {
+			return event.Source
+		}
+	case EXPECT_SUBJECT:
+		if airport.isParticipant(event.Subject) {
+			return event.Subject
+		}
+	case EXPECT_FIELD:
+		v, _ := DataPath(data, t.ExpectPath)
+		if name, ok := v.(string); ok && airport.isParticipant(name) {
+			return name
+		}
+	}
+	return ""
+}
+
+func (airport *Airport) isParticipant(name string) bool {
+	return airport.GetRetailer(name) != nil || airport.GetSupplier(name) != nil || airport.GetCarrier(name) != nil
+}
+
+// ReadTimeoutFile reads a list of rules from a YAML or JSON file.
+func ReadTimeoutFile(file string) ([]TimeoutEvent, error) {
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []TimeoutEvent
+	if err := yaml.Unmarshal(bytes, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// LoadTimeoutFile replaces the airport's rules with the contents of the
+// timeouts file, or with the defaults if there's no such file. A file with
+// errors leaves the rules as they are. Timeouts already running keep the rule
+// they started with.
+func (airport *Airport) LoadTimeoutFile(file string) {
+	rules, err := ReadTimeoutFile(file)
+	switch {
+	case os.IsNotExist(err):
+		slog.Info("No timeouts file, using the default rules", "airport", airport.Name, "file", file)
+		rules = append([]TimeoutEvent{}, DefaultTimeoutEvents...)
+	case err != nil:
+		slog.Error("Error reading timeouts file", "airport", airport.Name, "file", file, "err", err)
+		return
+	default:
+		slog.Info("Loaded timeout rules", "airport", airport.Name, "file", file, "rules", len(rules))
+	}
+
+	airport.rules_mu.Lock()
+	airport.timeoutEvents = rules
+	airport.rules_mu.Unlock()
+}
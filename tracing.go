@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Trace context travels between participants in the traceparent and
+// tracestate extensions of the CloudEvents distributed tracing extension.
+var propagator = propagation.TraceContext{}
+
+// tracer creates the controller's spans. Until SetupTracing is called they
+// aren't recorded, but the trace context still passes through.
+var tracer = otel.Tracer("airport-controller")
+
+// SetupTracing exports the controller's spans over OTLP/HTTP to the
+// collector at endpoint, e.g. http://localhost:4318, to /v1/traces unless
+// the URL has a path. The returned func flushes the spans not yet exported.
+func SetupTracing(endpoint string) (func(context.Context) error, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpointURL(endpoint)}
+	if strings.Trim(u.Path, "/") == "" {
+		opts = append(opts, otlptracehttp.WithURLPath("/v1/traces"))
+	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "airport-controller"))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// extensionCarrier reads and writes trace context in an event's extensions.
+type extensionCarrier struct {
+	event *CloudEvent
+}
+
+func (c extensionCarrier) Get(key string) string {
+	s, _ := c.event.Extensions[key].(string)
+	return s
+}
+
+func (c extensionCarrier) Set(key string, value string) {
+	c.event.SetExtension(key, value)
+}
+
+func (c extensionCarrier) Keys() []string {
+	keys := []string{}
+	for k := range c.event.Extensions {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Context returns the event's trace context: the span it was processed or
+// published in, or else the one its extensions carry.
+func (event *CloudEvent) Context() context.Context {
+	if event.ctx == nil {
+		event.ctx = propagator.Extract(context.Background(), extensionCarrier{event})
+	}
+	return event.ctx
+}
+
+// WithTrace returns the event to send on: a copy whose extensions carry its
+// trace context, or the event itself if it has none.
+func (event *CloudEvent) WithTrace() *CloudEvent {
+	if event.ctx == nil {
+		return event
+	}
+
+	e := *event
+	e.Extensions = map[string]interface{}{}
+	for k, v := range event.Extensions {
+		if k != "traceparent" && k != "tracestate" {
+			e.Extensions[k] = v
+		}
+	}
+	propagator.Inject(event.ctx, extensionCarrier{&e})
+	return &e
+}
+
+// StartSpan starts a span for the event, named after the operation and the
+// event's type, as a child of parent. The event's trace context becomes the
+// span's.
+func StartSpan(parent context.Context, event *CloudEvent, operation string, kind trace.SpanKind) trace.Span {
+	attrs := []attribute.KeyValue{
+		attribute.String("cloudevents.event_id", event.ID),
+		attribute.String("cloudevents.event_type", event.Type),
+		attribute.String("cloudevents.event_source", event.Source),
+		attribute.String("cloudevents.event_spec_version", event.SpecVersion),
+	}
+	if event.Subject != "" {
+		attrs = append(attrs, attribute.String("cloudevents.event_subject", event.Subject))
+	}
+	if event.Cause != "" {
+		attrs = append(attrs, attribute.String("cloudevents.event_cause", event.Cause))
+	}
+
+	ctx, span := tracer.Start(parent, "CloudEvents "+operation+" "+event.Type,
+		trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+	event.ctx = ctx
+	return span
+}
+
+// publishContext returns the trace context an event is published in: its
+// own if it has one, e.g. a resend, or else that of the event being handled.
+// The caller must hold the airport lock.
+func (airport *Airport) publishContext(event *CloudEvent) context.Context {
+	if event.ctx != nil {
+		return event.ctx
+	}
+	if airport.logEvent != nil {
+		return airport.logEvent.Context()
+	}
+	return context.Background()
+}
+
+// EndSpan ends the span, marking it failed if err isn't nil.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
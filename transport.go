@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// Transport moves CloudEvents between the controller and the participants.
+type Transport interface {
+	// Listen connects to the transport and calls handler for every event
+	// received, reconnecting whenever the connection is lost. onConnect is
+	// called each time a new connection is established. Listen returns once
+	// ctx is done and the events already received have been handled; the
+	// transport can still be sent on until Close.
+	Listen(ctx context.Context, onConnect func(), handler func(*CloudEvent))
+
+	// Send publishes event to every listener of the transport, including the
+	// controller itself.
+	Send(event *CloudEvent) error
+
+	// Close disconnects from the transport once Listen has returned.
+	Close() error
+}
+
+// ChannelTransport is an in-process Transport. Every event sent is delivered,
+// in order, to the controller and to every channel returned by Subscribe, so
+// the controller and its participants can run in a single process without a
+// message broker.
+type ChannelTransport struct {
+	mutex       sync.Mutex
+	subscribers []*channelSubscriber
+}
+
+type channelSubscriber struct {
+	mutex  sync.Mutex
+	queue  []*CloudEvent
+	signal chan struct{} // nudged whenever the queue grows, closed once unsubscribed
+}
+
+func NewChannelTransport() *ChannelTransport {
+	return &ChannelTransport{}
+}
+
+// subscribe adds a subscriber that's queued a copy of every event sent from
+// now on. Send is called with the airport locked, so it must never block on
+// a slow reader.
+func (t *ChannelTransport) subscribe() *channelSubscriber {
+	sub := &channelSubscriber{signal: make(chan struct{}, 1)}
+	t.mutex.Lock()
+	t.subscribers = append(t.subscribers, sub)
+	t.mutex.Unlock()
+	return sub
+}
+
+// unsubscribe stops queueing events for sub and closes its signal. What's
+// queued already stays there.
+func (t *ChannelTransport) unsubscribe(sub *channelSubscriber) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for i, s := range t.subscribers {
+		if s == sub {
+			t.subscribers = append(t.subscribers[:i], t.subscribers[i+1:]...)
+			break
+		}
+	}
+	close(sub.signal)
+}
+
+// next takes the oldest event off the queue, nil if it's empty.
+func (sub *channelSubscriber) next() *CloudEvent {
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+	if len(sub.queue) == 0 {
+		return nil
+	}
+	event := sub.queue[0]
+	sub.queue = sub.queue[1:]
+	return event
+}
+
+// Subscribe returns a channel that receives a copy of every event sent on
+// the transport from now on, until ctx is done.
+func (t *ChannelTransport) Subscribe(ctx context.Context) <-chan *CloudEvent {
+	sub := t.subscribe()
+	events := make(chan *CloudEvent)
+
+	// Events are handed over one at a time as the reader takes them
+	go func() {
+		for range sub.signal {
+			for event := sub.next(); event != nil; event = sub.next() {
+				select {
+				case events <- event:
+				case <-ctx.Done():
+				}
+			}
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		t.unsubscribe(sub)
+	}()
+
+	return events
+}
+
+// Listen handles the events in the order they were sent. Once ctx is done it
+// handles those sent before, then returns.
+func (t *ChannelTransport) Listen(ctx context.Context, onConnect func(), handler func(*CloudEvent)) {
+	sub := t.subscribe()
+	onConnect()
+	for {
+		select {
+		case <-sub.signal:
+			for event := sub.next(); event != nil; event = sub.next() {
+				handler(event)
+			}
+		case <-ctx.Done():
+			t.unsubscribe(sub)
+			for event := sub.next(); event != nil; event = sub.next() {
+				handler(event)
+			}
+			return
+		}
+	}
+}
+
+func (t *ChannelTransport) Close() error {
+	return nil
+}
+
+func (t *ChannelTransport) Send(event *CloudEvent) error {
+	event.SetDefaults()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, sub := range t.subscribers {
+		e := *event.WithTrace()
+		sub.mutex.Lock()
+		sub.queue = append(sub.queue, &e)
+		sub.mutex.Unlock()
+
+		select {
+		case sub.signal <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"testing"
+)
+
+func TestChannelListenDrains(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	transport := NewChannelTransport()
+
+	connected := make(chan struct{})
+	done := make(chan struct{})
+	var handled []string
+	go func() {
+		transport.Listen(ctx, func() { close(connected) }, func(event *CloudEvent) {
+			handled = append(handled, event.ID)
+		})
+		close(done)
+	}()
+
+	<-connected
+	for i := 0; i < 100; i++ {
+		transport.Send(&CloudEvent{ID: strconv.Itoa(i), Type: "Test", Source: "Test"})
+	}
+	cancel()
+	<-done
+
+	if len(handled) != 100 {
+		t.Fatalf("handled %d events before returning, want 100", len(handled))
+	}
+	for i, id := range handled {
+		if id != strconv.Itoa(i) {
+			t.Fatalf("event %s handled as number %d", id, i)
+		}
+	}
+}
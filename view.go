@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ViewProtocolVersion is the version of the messages sent on /ws_view. A
+// view opens with {"type":"hello","data":{"version":2}}; if it speaks this
+// version it's sent a hello back, then the airport's state and from then on
+// every change to it, otherwise the websocket is closed.
+//
+// Every message is {"type": ..., "data": ...}, data being one of the View*
+// structs. Participants are identified by their name, the event source,
+// and customers by their ID, so a message means the same whoever's come
+// and gone since it was sent.
+const ViewProtocolVersion = 2
+
+// viewHelloTimeout is how long a view has to say hello.
+const viewHelloTimeout = 10 * time.Second
+
+// ViewMessage is the data of a message to the views.
+type ViewMessage interface {
+	ViewType() string
+}
+
+type viewEnvelope struct {
+	Type string      `json:"type"`
+	Data ViewMessage `json:"data"`
+}
+
+// ViewHello opens the conversation, from either side.
+type ViewHello struct {
+	Version int `json:"version"`
+}
+
+// ViewState is the whole airport, sent after the hello and whenever it's
+// reset.
+type ViewState struct {
+	Disabled  bool            `json:"disabled"`
+	Catalog   Catalog         `json:"catalog"`
+	Retailers []*ViewRetailer `json:"retailers"`
+	Suppliers []*ViewSupplier `json:"suppliers"`
+	Carriers  []*ViewCarrier  `json:"carriers"`
+}
+
+// ViewEvent is an event a participant sent.
+type ViewEvent struct {
+	Event *CloudEvent `json:"event"`
+}
+
+// ViewRetailer is a retailer that's connected, or whose menu has changed.
+type ViewRetailer struct {
+	ID        string         `json:"id"`
+	Name      string         `json:"name"`
+	Logo      string         `json:"logo"`
+	Menu      []string       `json:"menu"`
+	Stock     map[string]int `json:"stock"`
+	Customers []string       `json:"customers"` // IDs, first in line first
+}
+
+// ViewSupplier is a supplier that's connected.
+type ViewSupplier struct {
+	ID   string `json:"id"`
+	Logo string `json:"logo"`
+}
+
+// ViewCarrier is a carrier that's connected.
+type ViewCarrier struct {
+	ID   string `json:"id"`
+	Logo string `json:"logo"`
+}
+
+// ViewLeft is a participant that's gone.
+type ViewLeft struct {
+	ID string `json:"id"`
+}
+
+// ViewCustomer is a customer that's joined a retailer's line.
+type ViewCustomer struct {
+	ID       string `json:"id"`
+	Retailer string `json:"retailer"`
+}
+
+// ViewJump is a customer jumping up and down.
+type ViewJump struct {
+	Customer string `json:"customer"`
+}
+
+// ViewSatisfied is a customer leaving, with or without what they ordered.
+type ViewSatisfied struct {
+	Customer string `json:"customer"`
+	Retailer string `json:"retailer"`
+}
+
+// ViewOrder is a retailer ordering a product from its supplier.
+type ViewOrder struct {
+	Retailer string `json:"retailer"`
+	Offer    string `json:"offer"`
+}
+
+// ViewInventory is a retailer's new stock of a product.
+type ViewInventory struct {
+	Retailer string `json:"retailer"`
+	Offer    string `json:"offer"`
+	Level    int    `json:"level"`
+}
+
+// ViewTransfer is a carrier setting off with a product from a supplier to a
+// retailer.
+type ViewTransfer struct {
+	Carrier  string `json:"carrier"`
+	Supplier string `json:"supplier"`
+	Retailer string `json:"retailer"`
+	Offer    string `json:"offer"`
+}
+
+// ViewTransferred is a carrier delivering a product to a retailer.
+type ViewTransferred struct {
+	Carrier  string `json:"carrier"`
+	Retailer string `json:"retailer"`
+	Offer    string `json:"offer"`
+}
+
+func (*ViewHello) ViewType() string       { return "hello" }
+func (*ViewState) ViewType() string       { return "state" }
+func (*ViewEvent) ViewType() string       { return "event" }
+func (*ViewRetailer) ViewType() string    { return "retailer" }
+func (*ViewSupplier) ViewType() string    { return "supplier" }
+func (*ViewCarrier) ViewType() string     { return "carrier" }
+func (*ViewLeft) ViewType() string        { return "left" }
+func (*ViewCustomer) ViewType() string    { return "customer" }
+func (*ViewJump) ViewType() string        { return "jump" }
+func (*ViewSatisfied) ViewType() string   { return "satisfied" }
+func (*ViewOrder) ViewType() string       { return "order" }
+func (*ViewInventory) ViewType() string   { return "inventory" }
+func (*ViewTransfer) ViewType() string    { return "transfer" }
+func (*ViewTransferred) ViewType() string { return "transferred" }
+
+// View returns the retailer as the views see it.
+func (retailer *Retailer) View() *ViewRetailer {
+	v := &ViewRetailer{
+		ID:        retailer.Name,
+		Name:      retailer.Nickname,
+		Logo:      retailer.Logo,
+		Menu:      retailer.Menu,
+		Stock:     retailer.Offers,
+		Customers: []string{},
+	}
+	for _, c := range retailer.Customers {
+		v.Customers = append(v.Customers, c.Id)
+	}
+	return v
+}
+
+// ViewState returns the airport as the views see it. The caller must hold
+// the airport lock.
+func (airport *Airport) ViewState() *ViewState {
+	state := &ViewState{
+		Disabled:  airport.Disabled,
+		Catalog:   airport.Catalog,
+		Retailers: []*ViewRetailer{},
+		Suppliers: []*ViewSupplier{},
+		Carriers:  []*ViewCarrier{},
+	}
+	for _, r := range airport.Retailers {
+		state.Retailers = append(state.Retailers, r.View())
+	}
+	for _, s := range airport.Suppliers {
+		state.Suppliers = append(state.Suppliers, &ViewSupplier{ID: s.Name, Logo: s.Logo})
+	}
+	for _, c := range airport.Carriers {
+		state.Carriers = append(state.Carriers, &ViewCarrier{ID: c.Name, Logo: c.Logo})
+	}
+	return state
+}
+
+func encodeView(msg ViewMessage) (string, error) {
+	body, err := json.Marshal(viewEnvelope{Type: msg.ViewType(), Data: msg})
+	return string(body), err
+}
+
+// Broadcast queues msg for every view. A view that has fallen too far behind
+// misses it rather than holding everyone else up. The caller must hold the
+// airport lock.
+func (airport *Airport) Broadcast(msg ViewMessage) {
+	body, err := encodeView(msg)
+	if err != nil {
+		airport.Log().Error("Error encoding view message", "type", msg.ViewType(), "err", err)
+		return
+	}
+
+	airport.clients_mu.Lock()
+	for _, c := range airport.clients {
+		select {
+		case c <- body:
+		default:
+			broadcastDrops.WithLabelValues(airport.Name).Inc()
+		}
+	}
+	airport.clients_mu.Unlock()
+}
+
+// removeClient stops broadcasting to a view and closes its channel, unless
+// that's been done already.
+func (airport *Airport) removeClient(client chan string) {
+	airport.clients_mu.Lock()
+	defer airport.clients_mu.Unlock()
+	for i, ch := range airport.clients {
+		if ch == client {
+			close(client)
+			airport.clients = append(airport.clients[:i], airport.clients[i+1:]...)
+			return
+		}
+	}
+}
+
+func (airport *Airport) HandleView(w http.ResponseWriter, r *http.Request) {
+	c, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500: \"" + err.Error() + "\""))
+		return
+	}
+
+	defer c.Close()
+	defer TrackSocket(c)()
+
+	var hello struct {
+		Type string    `json:"type"`
+		Data ViewHello `json:"data"`
+	}
+	c.SetReadDeadline(time.Now().Add(viewHelloTimeout))
+	if err := c.ReadJSON(&hello); err != nil || hello.Type != "hello" || hello.Data.Version != ViewProtocolVersion {
+		slog.Warn("View doesn't speak the protocol", "airport", airport.Name, "remote", r.RemoteAddr,
+			"version", hello.Data.Version, "err", err)
+		msg := websocket.FormatCloseMessage(websocket.CloseProtocolError,
+			"view protocol version "+strconv.Itoa(ViewProtocolVersion)+" required")
+		c.WriteControl(websocket.CloseMessage, msg, time.Now().Add(closeGrace))
+		return
+	}
+	c.SetReadDeadline(time.Time{})
+
+	// The state goes ahead of the changes to it, so the view is added under
+	// the airport lock
+	client := make(chan string, 0xFF)
+	airport.Mutex.RLock()
+	hi, _ := encodeView(&ViewHello{Version: ViewProtocolVersion})
+	state, err := encodeView(airport.ViewState())
+	airport.clients_mu.Lock()
+	client <- hi
+	if err == nil {
+		client <- state
+	}
+	airport.clients = append(airport.clients, client)
+	airport.clients_mu.Unlock()
+	airport.Mutex.RUnlock()
+
+	// Nothing else is expected from the view, but reading answers its pings
+	// and close, and notices it's gone even when nothing is sent to it
+	go func() {
+		for {
+			if _, _, err := c.NextReader(); err != nil {
+				airport.removeClient(client)
+				return
+			}
+		}
+	}()
+
+	for {
+		msg, ok := <-client
+		if !ok {
+			break
+		}
+
+		if err := c.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			airport.removeClient(client)
+			break
+		}
+	}
+}
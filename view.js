@@ -1,5 +1,6 @@
 var elEvent = document.getElementById("event");
 var elFids = document.getElementById("fids");
+var elLeaderboard = document.getElementById("leaderboard");
 
 var sprite = {
     truck: document.getElementById("truck"),
@@ -11,13 +12,14 @@ var sprite = {
     ce: document.getElementById("ce"),
 };
 
-function Supplier(logo) {
+function Supplier(s) {
+    this.id = s.id;
     this.width = 0;
     this.height = 0;
     this.x = 0;
     this.y = 0;
     this.logo = new Image();
-    this.logo.src = logo;
+    this.logo.src = s.logo;
 }
 
 function Truck(supplier, retailer) {
@@ -28,29 +30,34 @@ function Truck(supplier, retailer) {
     this.retailer = retailer;
 }
 
-function Carrier(logo) {
+function Carrier(c) {
+    this.id = c.id;
     this.width = 0;
     this.height = 0;
     this.x = 0;
     this.y = 0;
     this.logo = new Image();
-    this.logo.src = logo;
+    this.logo.src = c.logo;
     this.trucks = [];
 }
 
-function Retailer(logo) {
+function Retailer(r) {
+    this.id = r.id;
+    this.name = r.name;
+    this.menu = r.menu;
     this.width = 0;
     this.height = 0;
     this.x = 0;
     this.y = 0;
     this.customers = [];
-    this.small = this.medium = this.large = 0;
-    this.bsmall = this.bmedium = this.blarge = false;
+    this.stock = r.stock || {}; // by product ID
+    this.busy = {};             // products being delivered, by ID
     this.logo = new Image();
-    this.logo.src = logo;
+    this.logo.src = r.logo;
 }
 
-function Customer(retailer) {
+function Customer(retailer, id) {
+    this.id = id;
     this.time = 0;
     this.start = Date.now();
     this.retailer = retailer;
@@ -83,139 +90,175 @@ ctx.drawImage = function( ){
 var suppliers = [],
     carriers  = [],
     retailers = [],
-    customers = [];
+    customers = [],
+    catalog   = [];
 
-(function connect() {
-    suppliers.length = carriers.length = retailers.length = customers.length;
+// The version of the controller's view protocol this speaks
+var PROTOCOL_VERSION = 2;
 
-    httpGet("./data", function(x) {
-        if (x.readyState === 4) {
-            if (x.status === 200) {
-                var data = JSON.parse(x.responseText);
-                if (data) {
-                    if (data.suppliers) {
-                        for (var i = 0; i < data.suppliers.length; ++i) {
-                            suppliers.push(new Supplier(data.suppliers[i].logo));
-                        }
-                    }
+function find(list, id) {
+    for (var i = 0; i < list.length; ++i) {
+        if (list[i].id === id) return i;
+    }
+    return -1;
+}
 
-                    if (data.retailers) {
-                        for (var i = 0; i < data.retailers.length; ++i) {
-                            var dr = data.retailers[i];
-                            var r = new Retailer(dr.logo);
-                            if (dr.customers) {
-                                for (var e = 0; e < dr.customers.length; ++e) {
-                                    new Customer(r);
-                                }
-                            }
-
-                            if (dr.offers) {
-                                for (var e in dr.offers) {
-                                    r[e] = dr.offers[e];
-                                }
-                            }
-
-                            retailers.push(r);
-                        }
-                    }
+function findCustomer(id) {
+    for (var i = 0; i < retailers.length; ++i) {
+        var e = find(retailers[i].customers, id);
+        if (e >= 0) return retailers[i].customers[e];
+    }
+    return null;
+}
 
-                    carriers.length = 0;
-                    if (data.carriers) {
-                        for (var i = 0; i < data.carriers.length; ++i) {
-                            carriers.push(new Carrier(data.carriers[i].logo));
-                        }
-                    }
+function setState(state) {
+    suppliers.length = carriers.length = retailers.length = customers.length = 0;
 
-                    var ws = new WebSocket(((window.location.protocol === "https:") ? "wss://" : "ws://") + window.location.host + window.location.pathname.replace(/(view)(?!.*\/)/, "ws_view"));
-                    ws.onmessage = function(e) {
-                        var d = JSON.parse(e.data);
-                        if (d) {
-                            switch (d.type) {
-                                case "event": {
-                                    var row = elFids.insertRow(1);
-                                    var event = d.event;
-                                    var time = new Date(event.time);
-                                    var h = time.getHours();
-                                    if (h < 10) h = "0" + h;
-                                    var m = time.getMinutes();
-                                    if (m < 10) m = "0" + m;
-									var tt = event.type.split(".")[0];
-									row.innerHTML = "<td>" + h + ":" + m + "</td><td>" + event.source.split(".")[0] + "</td><td>" + tt + "</td>";
-                                    row.onclick = function(e) {
-                                        elEvent.children[0].innerText = JSON.stringify(event, null, 4);
-                                        elEvent.classList.remove("hide");
-                                        e.stopPropagation();
-                                    };
-                                    for (var i = elFids.rows.length; i > 50; --i) elFids.deleteRow(50);
-                                    break;
-                                }
-                                case "customer":
-                                    new Customer(retailers[d.r]);
-                                    break;
-                                case "jump": {
-                                    var c = retailers[d.r].customers[d.c];
-                                    if (c.z === 0) retailers[d.r].customers[d.c].vz = canvas.height * 0.0075;
-                                    break;
-                                }
-                                case "satisfied": {
-                                    var r = retailers[d.r];
-                                    var c =  r.customers.splice(d.c, 1)[0];
-                                    c.speed *= (Math.random() > 0.5 ? 1 : -1) * (0.75 + Math.random() * 0.5);
-                                    c.start = Date.now();
-                                    customers.push(c);
-                                    break;
-                                }
-                                case "retailer":
-                                    retailers.push(new Retailer(d.logo));
-                                    break;
-                                case "rmretailer": {
-                                    var r = retailers.splice(d.r, 1)[0];
-                                    for (var i = 0; i < r.customers.length; ++i) {
-                                        var c = r.customers[i];
-                                        c.speed = -0.5 + Math.random();
-                                        c.start = Date.now();
-                                        customers.push(c);
-                                    }
-                                    break;
-                                }
-                                case "supplier":
-                                    suppliers.push(new Supplier(d.logo));
-                                    break;
-                                case "rmsupplier":
-                                    for (var i = 0; i < carriers.length; ++i) {
-                                        if (carriers[i].supplier == d.s) {
-                                            carriers.splice(i--, 1);
-                                        }
-                                    }
-                                    suppliers.splice(d.s, 1);
-                                    break;
-                                case "carrier":
-                                    carriers.push(new Carrier(d.logo));
-                                    break;
-                                case "rmcarrier":
-                                    carriers.splice(d.c, 1);
-                                    break;
-                                case "gocarrier":
-                                    retailers[d.r]["b" + d.o] = true;
-                                    carriers[d.c].trucks.push(new Truck(suppliers[d.s], retailers[d.r]));
-                                    break;
-                                case "endcarrier":
-                                    retailers[d.r]["b" + d.o] = false;
-                                    break;
-                                case "offer":
-                                    retailers[d.r][d.o] = d.c;
-                                    break;
-                            }
-                        }
-                    };
+    catalog = state.catalog || [];
+    for (var i = 0; i < catalog.length; ++i) {
+        catalog[i].image = new Image();
+        catalog[i].image.src = catalog[i].icon || sprite.cup.src;
+    }
+
+    for (var i = 0; i < state.suppliers.length; ++i) {
+        suppliers.push(new Supplier(state.suppliers[i]));
+    }
+
+    for (var i = 0; i < state.retailers.length; ++i) {
+        var dr = state.retailers[i];
+        var r = new Retailer(dr);
+        for (var e = 0; e < dr.customers.length; ++e) {
+            new Customer(r, dr.customers[e]);
+        }
+        retailers.push(r);
+    }
 
-                    ws.onclose = connect;
+    for (var i = 0; i < state.carriers.length; ++i) {
+        carriers.push(new Carrier(state.carriers[i]));
+    }
+}
+
+(function connect() {
+    var ws = new WebSocket(((window.location.protocol === "https:") ? "wss://" : "ws://") + window.location.host + window.location.pathname.replace(/(view)(?!.*\/)/, "ws_view"));
+    ws.onopen = function() {
+        ws.send(JSON.stringify({ type: "hello", data: { version: PROTOCOL_VERSION } }));
+    };
+
+    ws.onmessage = function(e) {
+        var d = JSON.parse(e.data);
+        if (!d) return;
+
+        var m = d.data;
+        switch (d.type) {
+            case "state":
+                setState(m);
+                break;
+            case "event": {
+                var row = elFids.insertRow(1);
+                var event = m.event;
+                var time = new Date(event.time);
+                var h = time.getHours();
+                if (h < 10) h = "0" + h;
+                var min = time.getMinutes();
+                if (min < 10) min = "0" + min;
+                var tt = event.type.split(".")[0];
+                row.insertCell(-1).textContent = h + ":" + min;
+                row.insertCell(-1).textContent = event.source.split(".")[0];
+                row.insertCell(-1).textContent = tt;
+                row.onclick = function(e) {
+                    elEvent.children[0].innerText = JSON.stringify(event, null, 4);
+                    elEvent.classList.remove("hide");
+                    e.stopPropagation();
+                };
+                for (var i = elFids.rows.length; i > 50; --i) elFids.deleteRow(50);
+                break;
+            }
+            case "customer": {
+                var r = find(retailers, m.retailer);
+                if (r >= 0) new Customer(retailers[r], m.id);
+                break;
+            }
+            case "jump": {
+                var c = findCustomer(m.customer);
+                if (c && c.z === 0) c.vz = canvas.height * 0.0075;
+                break;
+            }
+            case "satisfied": {
+                var r = find(retailers, m.retailer);
+                var i = r >= 0 ? find(retailers[r].customers, m.customer) : -1;
+                if (i >= 0) {
+                    var c = retailers[r].customers.splice(i, 1)[0];
+                    c.speed *= (Math.random() > 0.5 ? 1 : -1) * (0.75 + Math.random() * 0.5);
+                    c.start = Date.now();
+                    customers.push(c);
                 }
-            } else {
-                setTimeout(connect, 500);
+                break;
+            }
+            case "retailer": {
+                var r = find(retailers, m.id);
+                if (r >= 0) {
+                    retailers[r].name = m.name;
+                    retailers[r].menu = m.menu;
+                } else {
+                    retailers.push(new Retailer(m));
+                }
+                break;
+            }
+            case "supplier":
+                suppliers.push(new Supplier(m));
+                break;
+            case "carrier":
+                carriers.push(new Carrier(m));
+                break;
+            case "left": {
+                var i;
+                if ((i = find(retailers, m.id)) >= 0) {
+                    var r = retailers.splice(i, 1)[0];
+                    for (var e = 0; e < r.customers.length; ++e) {
+                        var c = r.customers[e];
+                        c.speed = -0.5 + Math.random();
+                        c.start = Date.now();
+                        customers.push(c);
+                    }
+                } else if ((i = find(suppliers, m.id)) >= 0) {
+                    // Its trucks go with it
+                    var s = suppliers.splice(i, 1)[0];
+                    for (var e = 0; e < carriers.length; ++e) {
+                        for (var t = 0; t < carriers[e].trucks.length; ++t) {
+                            if (carriers[e].trucks[t].supplier === s) carriers[e].trucks[t].supplier = undefined;
+                        }
+                    }
+                } else if ((i = find(carriers, m.id)) >= 0) {
+                    carriers.splice(i, 1);
+                }
+                break;
+            }
+            case "transfer": {
+                var r = find(retailers, m.retailer);
+                var s = find(suppliers, m.supplier);
+                var c = find(carriers, m.carrier);
+                if (r >= 0 && s >= 0 && c >= 0) {
+                    retailers[r].busy[m.offer] = true;
+                    carriers[c].trucks.push(new Truck(suppliers[s], retailers[r]));
+                }
+                break;
+            }
+            case "transferred": {
+                var r = find(retailers, m.retailer);
+                if (r >= 0) retailers[r].busy[m.offer] = false;
+                break;
+            }
+            case "inventory": {
+                var r = find(retailers, m.retailer);
+                if (r >= 0) retailers[r].stock[m.offer] = m.level;
+                break;
             }
         }
-    });
+    };
+
+    ws.onclose = function() {
+        setTimeout(connect, 500);
+    };
 })();
 
 function drawImage(img, x, y, width, height, angle) {
@@ -411,37 +454,23 @@ function drawCupBar(x, y, c) {
         r.x = x;
         r.y = airport_y;
 
-        drawCupBar(r.x + r.width / 2.5, r.height * 0.15 + r.y + r.height / 3, r.large);
-        drawCupBar(r.x + r.width / 2.5, r.height * 0.15 + r.y + r.height / 2, r.medium);
-        drawCupBar(r.x + r.width / 2.5, r.height * 0.15 + r.y + r.height - r.height / 3, r.small);
-
-        if (r.bsmall) {
-            ctx.strokeStyle = "black";
-            ctx.fillStyle = "white";
-            var s = r.width * 0.5;
-            var cx = r.x - r.width;
-            drawBubble(r.x - r.width / 2, r.y + r.height / 4, cx, r.y, s, s, s * 0.25);
-            var cs = s * 0.5;
-            ctx.drawImage(sprite.cup, cx + cs / 2, r.y - s * 0.8, cs, cs);
-        }
-
-        if (r.bmedium) {
-            ctx.strokeStyle = "black";
-            ctx.fillStyle = "white";
-            var s = r.width * 0.5;
-            var cx = r.x - s / 2;
-            drawBubble(r.x, r.y, cx, r.y - s / 2, s, s, s * 0.25);
-            var cs = s * 0.75;
-            ctx.drawImage(sprite.cup, cx + s * 0.15, r.y - s - cs / 1.5, cs, cs);
-        }
-
-        if (r.blarge) {
-            ctx.strokeStyle = "black";
-            ctx.fillStyle = "white";
-            var s = r.width * 0.5;
-            var cx = r.x + r.width / 2;
-            drawBubble(r.x + r.width / 2, r.y + r.height / 4, cx, r.y, s, s, s * 0.25);
-            ctx.drawImage(sprite.cup, cx, r.y - s * 1.1, s, s);
+        // The first product's bar at the bottom, the rest stacked above it and
+        // their bubbles left to right
+        for (var p = 0, n = catalog.length; p < n; ++p) {
+            var f = n > 1 ? p / (n - 1) : 0.5;
+            drawCupBar(r.x + r.width / 2.5, r.height * 0.15 + r.y + r.height / 3 + (r.height / 3) * (1 - f), r.stock[catalog[p].id] || 0);
+
+            if (r.busy[catalog[p].id]) {
+                ctx.strokeStyle = "black";
+                ctx.fillStyle = "white";
+                var s = r.width * 0.5;
+                var cx = r.x - s * 2 + s * 3 * f;
+                var edge = Math.abs(2 * f - 1);
+                var cy = r.y - (s / 2) * (1 - edge);
+                drawBubble(r.x - s + s * 2 * f, r.y + (r.height / 4) * edge, cx, cy, s, s, s * 0.25);
+                var cs = s * 0.75;
+                ctx.drawImage(catalog[p].image, cx + (s - cs) / 2, cy - s + (s - cs) / 2, cs, cs);
+            }
         }
 
         ctx.drawImage(sprite.shop, r.x - r.width / 2, r.y, r.width, r.height);
@@ -526,6 +555,27 @@ function drawCupBar(x, y, c) {
     setTimeout(update, 17);
 })();
 
+(function leaderboard() {
+    httpGet("./stats", function(x) {
+        if (x.readyState === 4) {
+            if (x.status === 200) {
+                var stats = JSON.parse(x.responseText) || [];
+                while (elLeaderboard.rows.length > 1) elLeaderboard.deleteRow(1);
+                for (var i = 0; i < stats.length && i < 10; ++i) {
+                    var s = stats[i];
+                    var row = elLeaderboard.insertRow(-1);
+                    row.insertCell(-1).textContent = i + 1;
+                    row.insertCell(-1).textContent = s.name.split(".").slice(1).join(".") + " (" + s.role[0] + ")";
+                    row.insertCell(-1).textContent = Math.round(s.onTime * 100) + "%";
+                    row.insertCell(-1).textContent = s.responses > 0 ? (s.medianMs / 1000).toFixed(1) + "s" : "-";
+                    row.title = s.responses + " responses, " + s.timeouts + " timeouts, " + s.disconnects + " disconnects";
+                }
+            }
+            setTimeout(leaderboard, 2000);
+        }
+    });
+})();
+
 function httpGet(url, state) {
     var x = new XMLHttpRequest();
     x.onreadystatechange = function() {
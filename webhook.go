@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// HTTPSink is a webhook that receives, in binary mode, every event the
+// controller publishes. Delivery is asynchronous so a slow sink never holds
+// up the airport.
+type HTTPSink struct {
+	URL    string
+	events chan *CloudEvent
+}
+
+var sinkClient = &http.Client{Timeout: 10 * time.Second}
+
+// SinkList collects the repeatable '-sink' flag.
+type SinkList []string
+
+func (l *SinkList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *SinkList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// ParseSink splits a '-sink' value into the airport whose events the sink
+// receives and its URL. The airport is empty for a sink receiving every
+// airport's events.
+func ParseSink(value string) (string, string) {
+	if i := strings.Index(value, "="); i > 0 && airportName.MatchString(value[:i]) {
+		return value[:i], value[i+1:]
+	}
+	return "", value
+}
+
+func NewHTTPSink(url string) *HTTPSink {
+	sink := &HTTPSink{URL: url, events: make(chan *CloudEvent, 0xFF)}
+	go sink.run()
+	return sink
+}
+
+func (sink *HTTPSink) Send(event *CloudEvent) {
+	e := *event
+	select {
+	case sink.events <- &e:
+	default:
+		slog.Warn("Dropped event for sink, queue is full", EventAttr(event), "sink", sink.URL)
+	}
+}
+
+func (sink *HTTPSink) run() {
+	for event := range sink.events {
+		req, err := EventToRequest(sink.URL, event)
+		if err != nil {
+			slog.Error("Error building request for sink", EventAttr(event), "sink", sink.URL, "err", err)
+			continue
+		}
+
+		resp, err := sinkClient.Do(req)
+		if err != nil {
+			slog.Error("Error delivering event to sink", EventAttr(event), "sink", sink.URL, "err", err)
+			continue
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode/100 != 2 {
+			slog.Warn("Sink rejected event", EventAttr(event), "sink", sink.URL, "status", resp.Status)
+		}
+	}
+}
+
+// EventToRequest builds a binary-mode CloudEvents HTTP request for event.
+func EventToRequest(url string, event *CloudEvent) (*http.Request, error) {
+	event.SetDefaults()
+	event = event.WithTrace()
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(event.Data))
+	if err != nil {
+		return nil, err
+	}
+
+	contentType := event.DataContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("ce-specversion", event.SpecVersion)
+	req.Header.Set("ce-type", event.Type)
+	req.Header.Set("ce-source", event.Source)
+	req.Header.Set("ce-id", event.ID)
+	req.Header.Set("ce-time", event.Time)
+	if event.Subject != "" {
+		req.Header.Set("ce-subject", event.Subject)
+	}
+	for k, v := range event.Extensions {
+		req.Header.Set("ce-"+k, fmt.Sprint(v))
+	}
+	if event.Cause != "" {
+		req.Header.Set("ce-cause", event.Cause)
+	}
+	if event.DataSchema != "" {
+		if event.SpecVersion == SpecVersion03 {
+			req.Header.Set("ce-schemaurl", event.DataSchema)
+		} else {
+			req.Header.Set("ce-dataschema", event.DataSchema)
+		}
+	}
+
+	return req, nil
+}
+
+// RequestToEvent reads a CloudEvent from an HTTP request in either binary
+// (ce-* headers) or structured (application/cloudevents+json) mode.
+func RequestToEvent(r *http.Request) (*CloudEvent, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	event := CloudEvent{}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/cloudevents+json" {
+		if err := json.Unmarshal(body, &event); err != nil {
+			return nil, err
+		}
+	} else {
+		event.SpecVersion = r.Header.Get("ce-specversion")
+		event.Type = r.Header.Get("ce-type")
+		event.Source = r.Header.Get("ce-source")
+		event.Subject = r.Header.Get("ce-subject")
+		event.ID = r.Header.Get("ce-id")
+		event.Time = r.Header.Get("ce-time")
+		event.Cause = r.Header.Get("ce-cause")
+		event.DataContentType = r.Header.Get("Content-Type")
+		if event.SpecVersion == SpecVersion03 {
+			event.DataSchema = r.Header.Get("ce-schemaurl")
+		} else {
+			event.DataSchema = r.Header.Get("ce-dataschema")
+		}
+		for k, v := range r.Header {
+			name := strings.ToLower(k)
+			if strings.HasPrefix(name, "ce-") && !coreAttributes[name[3:]] && len(v) > 0 {
+				event.SetExtension(name[3:], v[0])
+			}
+		}
+		if len(body) > 0 {
+			event.Data = body
+		}
+	}
+
+	if event.SpecVersion == "" || event.Type == "" || event.Source == "" || event.ID == "" {
+		return nil, errors.New("missing required attribute: specversion, type, source and id are required")
+	}
+
+	return &event, nil
+}
+
+// controlEvents are the events only the controller sends, which no participant
+// can send over HTTP. Participants leave with a Disconnect of their own.
+var controlEvents = map[string]bool{"Reset": true}
+
+// ReadEventTokensFile reads the participants' tokens from a YAML or JSON map
+// of source to token.
+func ReadEventTokensFile(file string) (map[string]string, error) {
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens map[string]string
+	if err := yaml.Unmarshal(bytes, &tokens); err != nil {
+		return nil, err
+	}
+	for source, token := range tokens {
+		if token == "" {
+			return nil, errors.New("empty token for " + source)
+		}
+	}
+	return tokens, nil
+}
+
+// LoadEventTokensFile replaces the bearer tokens the airport's participants
+// post to /events with, by the source of their events, with the contents of
+// the tokens file, or with none if there's no such file. A file with errors
+// leaves the tokens as they are.
+func (airport *Airport) LoadEventTokensFile(file string) {
+	tokens, err := ReadEventTokensFile(file)
+	switch {
+	case os.IsNotExist(err):
+		slog.Info("No event tokens file, the events endpoint is disabled", "airport", airport.Name, "file", file)
+	case err != nil:
+		slog.Error("Error reading event tokens file", "airport", airport.Name, "file", file, "err", err)
+		return
+	default:
+		slog.Info("Loaded event tokens", "airport", airport.Name, "file", file, "participants", len(tokens))
+	}
+
+	airport.rules_mu.Lock()
+	airport.eventTokens = tokens
+	airport.rules_mu.Unlock()
+}
+
+// eventTokenValid reports whether the request carries the token of the
+// event's source.
+func (airport *Airport) eventTokenValid(r *http.Request, source string) bool {
+	airport.rules_mu.RLock()
+	want, ok := airport.eventTokens[source]
+	airport.rules_mu.RUnlock()
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1
+}
+
+// HandleEvents accepts CloudEvents from participants that can't hold an
+// AMQP link. Each participant authenticates with the bearer token the event
+// tokens file gives its source. The event is relayed on the transport like
+// any other participant's event, so the rest of the airport sees it and the
+// controller processes it when the transport delivers it back.
+func (airport *Airport) HandleEvents(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	airport.rules_mu.RLock()
+	enabled := len(airport.eventTokens) > 0
+	airport.rules_mu.RUnlock()
+	if !enabled {
+		writeError(w, http.StatusForbidden, "events endpoint is disabled, list the participants' tokens in the '-event-tokens' file to enable it")
+		return
+	}
+
+	event, err := RequestToEvent(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400: \"" + err.Error() + "\""))
+		return
+	}
+
+	if !airport.eventTokenValid(r, event.Source) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="airport"`)
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	if controlEvents[event.Type] || event.Source == "Controller" {
+		writeError(w, http.StatusForbidden, "the controller's "+event.Type+" events can't be sent over HTTP")
+		return
+	}
+
+	if err := airport.Transport.Send(event); err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("502: \"" + err.Error() + "\""))
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}